@@ -1,9 +1,8 @@
 package p2pub
 
 import (
+	"fmt"
 	"time"
-	"log"
-	"regexp"
 	"errors"
 
 	"github.com/iij/p2pubapi"
@@ -90,10 +89,49 @@ func dataSourceAdditionalStorage() *schema.Resource {
 				Optional: true,
 				Computed: true,
 			},
+			"attached_virtual_server": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+			"pci_slot": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+			"resource_status": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+			"contract_status": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
 		},
 	}
 }
 
+// additionalStorageFilterFields returns the attributes of each storage in the
+// list, which filters are compared with
+func additionalStorageFilterFields(storages *protocol.StorageListGetResponse) []map[string]string {
+	fields := make([]map[string]string, 0)
+	for _, storage := range storages.AdditionalStorageList {
+		fields = append(fields, map[string]string{
+			"service_code":            storage.ServiceCode,
+			"os_type":                 storage.OSType,
+			"label":                   storage.Label,
+			"type":                    storage.Type,
+			"attached":                fmt.Sprintf("%v", storage.ResourceStatus == p2pubapi.Attached.String()),
+			"attached_virtual_server": storage.AttachedVirtualServer.ServiceCode,
+			"resource_status":         storage.ResourceStatus,
+			"contract_status":         storage.ContractStatus,
+		})
+	}
+	return fields
+}
+
 func getAdditionalStorageList(api *p2pubapi.API, gis string) (*protocol.StorageListGetResponse, error) {
 	args := protocol.StorageListGet{
 		GisServiceCode: gis,
@@ -119,31 +157,9 @@ func dataSourceAdditionalStorageRead(d *schema.ResourceData, m interface{}) erro
 		return err
 	}
 
-	var matches []int
-	for idx, storage := range storages.AdditionalStorageList {
-		if d.Get("service_code") == storage.ServiceCode {
-			matches = []int{ idx }
-			break
-		}
-		match := true
-		for _, f := range d.Get("filter").([]interface{}) {
-			filter := f.(map[string]interface{})
-			switch filter["name"] {
-			case "os_type":
-				match = match && filter["value"] == storage.OSType
-			case "label":
-				matched , _ := regexp.MatchString(filter["value"].(string), storage.Label)
-				match = match && matched
-			case "type":
-				match = match && filter["value"] == storage.Type
-			default:
-				log.Printf("[ERROR] filter by '%s' not supported", filter["name"])
-				return errors.New("invalid filter")
-			}
-		}
-		if match {
-			matches = append(matches, idx)
-		}
+	matches, err := filterStorages(additionalStorageFilterFields(storages), d.Get("service_code").(string), d.Get("filter").([]interface{}))
+	if err != nil {
+		return err
 	}
 
 	if len(matches) == 0 {
@@ -177,6 +193,19 @@ func dataSourceAdditionalStorageRead(d *schema.ResourceData, m interface{}) erro
 	d.Set("service_code", ans.ServiceCode)
 	d.Set("encryption", ans.Encryption)
 	d.Set("mode", ans.Mode)
+	d.Set("resource_status", ans.ResourceStatus)
+	d.Set("contract_status", ans.ContractStatus)
+
+	ivm := ""
+	pci := ""
+	if ans.ResourceStatus == p2pubapi.Attached.String() {
+		ivm = ans.AttachedVirtualServer.ServiceCode
+		if pci, err = findStoragePciSlot(api, gis, ivm, ans.ServiceCode, true); err != nil {
+			return err
+		}
+	}
+	d.Set("attached_virtual_server", ivm)
+	d.Set("pci_slot", pci)
 	
 	return nil
 }
@@ -0,0 +1,45 @@
+package p2pub
+
+import (
+	"regexp"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// additional storage types available on P2PUB. see http://manual.iij.jp/p2/pubapi/59949023.html
+var additionalStorageTypes = []string{
+	"B100GB", "B500GB", "B1000GB", "B2000GB",
+	"G100GB", "G500GB", "G1000GB",
+	"BX100GB", "BX500GB", "BX1000GB", "BX2000GB",
+	"GX100GB", "GX500GB", "GX1000GB",
+}
+
+var additionalStorageTypePattern = regexp.MustCompile(`^(BX|GX|B|G)(\d+)GB$`)
+
+func getAdditionalStorageTypes() []storageType {
+	types := make([]storageType, 0)
+	for _, t := range additionalStorageTypes {
+		m := additionalStorageTypePattern.FindStringSubmatch(t)
+		if m == nil {
+			continue
+		}
+		types = append(types, storageType{
+			Type:          t,
+			Size:          m[2],
+			Encryption:    isExtendedAdditionalStorage(t),
+			StorageGroups: storageGroups,
+		})
+	}
+	return types
+}
+
+func dataSourceAdditionalStorageTypes() *schema.Resource {
+	return &schema.Resource{
+		Read:   dataSourceAdditionalStorageTypesRead,
+		Schema: storageTypesSchema(),
+	}
+}
+
+func dataSourceAdditionalStorageTypesRead(d *schema.ResourceData, m interface{}) error {
+	return readStorageTypes(d, getAdditionalStorageTypes())
+}
@@ -81,6 +81,17 @@ func dataSourceCustomOSImage() *schema.Resource {
 	}
 }
 
+// archivedImage is an image in a Storage Archive
+type archivedImage struct {
+	ImageId          string
+	Label            string
+	Type             string
+	OSType           string
+	ImageSize        string
+	ArchivedDateTime string
+	SrcServiceCode   string
+}
+
 //
 // api call
 //
@@ -108,6 +119,72 @@ func getCustomOSImageList(api *p2pubapi.API, gis, iar string) (*protocol.CustomO
 	return &res, nil
 }
 
+func getArchivedImages(api *p2pubapi.API, gis, iar string) ([]archivedImage, error) {
+	res, err := getCustomOSImageList(api, gis, iar)
+	if err != nil {
+		return nil, err
+	}
+	images := make([]archivedImage, 0, len(res.ImageList))
+	for _, image := range res.ImageList {
+		images = append(images, archivedImage{
+			ImageId:          image.ImageId,
+			Label:            image.Label,
+			Type:             image.Type,
+			OSType:           image.OSType,
+			ImageSize:        image.ImageSize,
+			ArchivedDateTime: image.ArchivedDateTime,
+			SrcServiceCode:   image.SrcServiceCode,
+		})
+	}
+	return images, nil
+}
+
+// archivedImageSchema is the schema of an image in image_list
+func archivedImageSchema() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		"image_id": &schema.Schema{
+			Type:     schema.TypeString,
+			Computed: true,
+		},
+		"label": &schema.Schema{
+			Type:     schema.TypeString,
+			Computed: true,
+		},
+		"type": &schema.Schema{
+			Type:     schema.TypeString,
+			Computed: true,
+		},
+		"os_type": &schema.Schema{
+			Type:     schema.TypeString,
+			Computed: true,
+		},
+		"image_size": &schema.Schema{
+			Type:     schema.TypeString,
+			Computed: true,
+		},
+		"created_at": &schema.Schema{
+			Type:     schema.TypeString,
+			Computed: true,
+		},
+		"source": &schema.Schema{
+			Type:     schema.TypeString,
+			Computed: true,
+		},
+	}
+}
+
+func archivedImageAttributes(image archivedImage) map[string]interface{} {
+	return map[string]interface{}{
+		"image_id":   image.ImageId,
+		"label":      image.Label,
+		"type":       image.Type,
+		"os_type":    image.OSType,
+		"image_size": image.ImageSize,
+		"created_at": image.ArchivedDateTime,
+		"source":     image.SrcServiceCode,
+	}
+}
+
 func dataSourceCustomOSImageRead(d *schema.ResourceData, m interface{}) error {
 
 	if d.Get("filter") == nil && d.Get("image_id") == "" {
@@ -128,13 +205,13 @@ func dataSourceCustomOSImageRead(d *schema.ResourceData, m interface{}) error {
 
 	iar := contract.StorageArchive.ServiceCode
 
-	images, err := getCustomOSImageList(api, gis, iar)
+	images, err := getArchivedImages(api, gis, iar)
 	if err != nil {
 		return err
 	}
 
 	var matches []int
-	for idx, image := range images.ImageList {
+	for idx, image := range images {
 		if d.Get("image_id") == image.ImageId {
 			matches = []int{ idx }
 			break
@@ -174,23 +251,19 @@ func dataSourceCustomOSImageRead(d *schema.ResourceData, m interface{}) error {
 		picked := 0
 		last_modified := "ZZZ"
 		for _, idx := range matches {
-			if images.ImageList[idx].ArchivedDateTime < last_modified {
+			if images[idx].ArchivedDateTime < last_modified {
 				picked = idx
-				last_modified = images.ImageList[idx].ArchivedDateTime
+				last_modified = images[idx].ArchivedDateTime
 			}
 		}
 		matches = []int{ picked }
 	}
 
-	ans := images.ImageList[matches[0]]
+	ans := images[matches[0]]
 	d.SetId(ans.ImageId)
-	d.Set("os_type", ans.OSType)
-	d.Set("created_at", ans.ArchivedDateTime)
-	d.Set("label", ans.Label)
-	d.Set("source", ans.SrcServiceCode)
-	d.Set("image_id", ans.ImageId)
-	d.Set("image_size", ans.ImageSize)
-	d.Set("type", ans.Type)
+	for k, v := range archivedImageAttributes(ans) {
+		d.Set(k, v)
+	}
 	
 	return nil
 }
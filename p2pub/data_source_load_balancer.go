@@ -1,10 +1,14 @@
 package p2pub
 
 import (
-	"fmt"
+	"errors"
+	"log"
+	"regexp"
 	"time"
 
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/iij/p2pubapi"
+	"github.com/iij/p2pubapi/protocol"
 )
 
 func dataSourceLoadBalancer() *schema.Resource {
@@ -14,73 +18,113 @@ func dataSourceLoadBalancer() *schema.Resource {
 			Default: schema.DefaultTimeout(5 * time.Minute),
 		},
 		Schema: map[string]*schema.Schema{
+			"filter": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"value": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+					},
+				},
+			},
+			"most_recent": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"service_code": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+
+			//
+			//
+
 			// D10M, D100M, D150M, D1000M
 			"type": &schema.Schema{
 				Type:     schema.TypeString,
-				Required: true,
+				Computed: true,
 			},
 			"redundant": &schema.Schema{
 				Type:     schema.TypeString,
-				Required: true,
+				Computed: true,
 			},
 			"label": &schema.Schema{
 				Type:     schema.TypeString,
-				Optional: true,
+				Computed: true,
+			},
+			"software_version": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
 			},
 			// Global, PrivateStandard, Private
 			"external_type": &schema.Schema{
 				Type:     schema.TypeString,
-				Required: true,
+				Computed: true,
 			},
 			"external_trafficip_name": &schema.Schema{
 				Type:     schema.TypeString,
-				Required: true,
+				Computed: true,
 			},
 			"external_servicecode": &schema.Schema{
 				Type:     schema.TypeString,
-				Optional: true,
+				Computed: true,
 			},
 			"external_trafficip_address": &schema.Schema{
 				Type:     schema.TypeString,
-				Optional: true,
 				Computed: true,
 			},
 			"external_masterhost_address": &schema.Schema{
 				Type:     schema.TypeString,
-				Optional: true,
+				Computed: true,
 			},
 			"external_slavehost_address": &schema.Schema{
 				Type:     schema.TypeString,
-				Optional: true,
+				Computed: true,
 			},
 			"external_netmask": &schema.Schema{
 				Type:     schema.TypeString,
-				Optional: true,
+				Computed: true,
 			},
 			// PrivateStandard, Private
 			"internal_type": &schema.Schema{
 				Type:     schema.TypeString,
-				Required: true,
+				Computed: true,
 			},
 			"internal_trafficip_address": &schema.Schema{
 				Type:     schema.TypeString,
-				Optional: true,
+				Computed: true,
 			},
 			"internal_masterhost_address": &schema.Schema{
 				Type:     schema.TypeString,
-				Optional: true,
+				Computed: true,
 			},
 			"internal_slavehost_address": &schema.Schema{
 				Type:     schema.TypeString,
-				Optional: true,
+				Computed: true,
 			},
 			"internal_netmask": &schema.Schema{
 				Type:     schema.TypeString,
-				Optional: true,
+				Computed: true,
 			},
 			"internal_servicecode": &schema.Schema{
 				Type:     schema.TypeString,
-				Optional: true,
+				Computed: true,
+			},
+			"administration_server_allow_network_list": &schema.Schema{
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				Computed: true,
 			},
 			"trafficip_list": &schema.Schema{
 				Type: schema.TypeList,
@@ -146,6 +190,78 @@ func dataSourceLoadBalancer() *schema.Resource {
 				},
 				Computed: true,
 			},
+			"filter_in_list": &schema.Schema{
+				Type: schema.TypeList,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"filter_id": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"source_network": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"destination_network": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"destination_port": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"protocol": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"action": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"label": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+				Computed: true,
+			},
+			"filter_out_list": &schema.Schema{
+				Type: schema.TypeList,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"filter_id": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"source_network": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"destination_network": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"destination_port": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"protocol": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"action": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"label": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+				Computed: true,
+			},
 			"static_route_list": &schema.Schema{
 				Type: schema.TypeList,
 				Elem: &schema.Resource{
@@ -156,30 +272,114 @@ func dataSourceLoadBalancer() *schema.Resource {
 						},
 						"destination": &schema.Schema{
 							Type:     schema.TypeString,
-							Required: true,
+							Computed: true,
 						},
 						"gateway": &schema.Schema{
 							Type:     schema.TypeString,
-							Required: true,
+							Computed: true,
 						},
 						"servicecode": &schema.Schema{
 							Type:     schema.TypeString,
-							Optional: true,
+							Computed: true,
 						},
 					},
 				},
-				Optional: true,
+				Computed: true,
 			},
 		},
 	}
 }
 
+func getLoadBalancerList(api *p2pubapi.API, gis string) (*protocol.FwLbListGetResponse, error) {
+	args := protocol.FwLbListGet{
+		GisServiceCode: gis,
+	}
+	var res = protocol.FwLbListGetResponse{}
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
 func dataSourceLoadBalancerRead(d *schema.ResourceData, m interface{}) error {
-	if d.Get("service_code") == nil {
-		return fmt.Errorf("service_code is required")
+
+	if len(d.Get("filter").([]interface{})) == 0 && d.Get("service_code") == "" {
+		return errors.New("filter or service_code is required")
+	}
+
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+
+	lbs, err := getLoadBalancerList(api, gis)
+	if err != nil {
+		return err
+	}
+
+	var matches []int
+	for idx, lb := range lbs.FwLbList {
+		if d.Get("service_code") == lb.ServiceCode {
+			matches = []int{idx}
+			break
+		}
+		if d.Get("service_code") != "" {
+			continue
+		}
+		match := true
+		for _, f := range d.Get("filter").([]interface{}) {
+			filter := f.(map[string]interface{})
+			switch filter["name"] {
+			case "label":
+				matched, _ := regexp.MatchString(filter["value"].(string), lb.Label)
+				match = match && matched
+			case "type":
+				match = match && filter["value"] == lb.Type
+			case "redundant":
+				match = match && filter["value"] == lb.Redundant
+			case "external_type":
+				match = match && filter["value"] == lb.External.NetworkType
+			case "internal_type":
+				match = match && filter["value"] == lb.Internal.NetworkType
+			default:
+				log.Printf("[ERROR] filter by '%s' not supported", filter["name"])
+				return errors.New("invalid filter")
+			}
+		}
+		if match {
+			matches = append(matches, idx)
+		}
+	}
+
+	if len(matches) == 0 {
+		return errors.New("no load balancers matched")
+	}
+
+	if len(matches) >= 2 {
+		if !d.Get("most_recent").(bool) {
+			return errors.New("two or more load balancers matched. please narrow down")
+		}
+		picked := matches[0]
+		last_modified := ""
+		for _, idx := range matches {
+			if lbs.FwLbList[idx].StartDate > last_modified {
+				picked = idx
+				last_modified = lbs.FwLbList[idx].StartDate
+			}
+		}
+		matches = []int{picked}
+	}
+
+	ans := lbs.FwLbList[matches[0]]
+
+	d.SetId(ans.ServiceCode)
+	d.Set("service_code", ans.ServiceCode)
+
+	if err := resourceLoadBalancerRead(d, m); err != nil {
+		return err
 	}
 
-	d.SetId(d.Get("service_code").(string))
+	if trafficips := d.Get("trafficip_list").([]interface{}); len(trafficips) != 0 {
+		d.Set("external_trafficip_name", trafficips[0].(map[string]interface{})["ipv4_name"])
+	}
 
-	return resourceLoadBalancerRead(d, m)
+	return nil
 }
@@ -0,0 +1,215 @@
+package p2pub
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/hashcode"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+type serverType struct {
+	Type         string
+	CPU          string
+	Memory       string
+	ServerGroups []string
+	PriceClass   string
+}
+
+// catalog of server types available on P2PUB. P2PUB API has no call listing
+// them, so this table is kept in sync with the manual by hand: add a row when
+// a type appears in http://manual.iij.jp/p2/pubapi/59949011.html, and take
+// server groups from http://manual.iij.jp/p2/pub/b-1-5.html. cpu and memory
+// (in GB) are listed as in the type name, except that VB0 has one cpu.
+var serverTypeCatalog = []serverType{
+	{"VB0-1", "1", "1", []string{"A", "B"}, "BestEffort"},
+	{"VB1-2", "1", "2", []string{"A", "B"}, "BestEffort"},
+	{"VB2-4", "2", "4", []string{"A", "B"}, "BestEffort"},
+	{"VB4-8", "4", "8", []string{"A", "B"}, "BestEffort"},
+	{"VB8-16", "8", "16", []string{"A", "B"}, "BestEffort"},
+	{"VB16-32", "16", "32", []string{"A", "B"}, "BestEffort"},
+	{"VG1-2", "1", "2", []string{"A", "B"}, "Guarantee"},
+	{"VG2-4", "2", "4", []string{"A", "B"}, "Guarantee"},
+	{"VG4-8", "4", "8", []string{"A", "B"}, "Guarantee"},
+	{"VG8-16", "8", "16", []string{"A", "B"}, "Guarantee"},
+	{"VG16-32", "16", "32", []string{"A", "B"}, "Guarantee"},
+	{"VD4-16", "4", "16", []string{"A", "B"}, "Dedicated"},
+	{"VD8-32", "8", "32", []string{"A", "B"}, "Dedicated"},
+	{"VD16-64", "16", "64", []string{"A", "B"}, "Dedicated"},
+	{"VD32-128", "32", "128", []string{"A", "B"}, "Dedicated"},
+}
+
+func getServerTypes() []serverType {
+	return serverTypeCatalog
+}
+
+// findServerType returns the catalog entry of the type, or nil
+func findServerType(name string) *serverType {
+	for i := range serverTypeCatalog {
+		if serverTypeCatalog[i].Type == name {
+			return &serverTypeCatalog[i]
+		}
+	}
+	return nil
+}
+
+// validateServerType only warns of a type missing from the catalog, since a
+// new VM of a type newer than the catalog is still worth trying. changing the
+// type of an existing VM is checked by validateServerTypeDiff instead.
+func validateServerType(v interface{}, k string) ([]string, []error) {
+	if findServerType(v.(string)) != nil {
+		return nil, nil
+	}
+	return []string{fmt.Sprintf("%s: unknown server type %s. see data source p2pub_server_types", k, v.(string))}, nil
+}
+
+// validateServerTypeDiff rejects an unknown type for an existing VM, which
+// would otherwise be stopped before P2PUB refuses the type, and a server
+// group the type is not available in
+func validateServerTypeDiff(d *schema.ResourceDiff) error {
+	if !d.NewValueKnown("type") {
+		return nil
+	}
+	stype := d.Get("type").(string)
+	t := findServerType(stype)
+	if t == nil {
+		if d.Id() != "" && d.HasChange("type") {
+			return fmt.Errorf("type: unknown server type %s. see data source p2pub_server_types", stype)
+		}
+		return nil
+	}
+	if group, ok := d.GetOk("server_group"); ok && d.NewValueKnown("server_group") {
+		for _, g := range t.ServerGroups {
+			if g == group.(string) {
+				return nil
+			}
+		}
+		return fmt.Errorf("server_group: %s is not available for type %s", group.(string), stype)
+	}
+	return nil
+}
+
+func dataSourceServerTypes() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceServerTypesRead,
+
+		Schema: map[string]*schema.Schema{
+			"filter": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"value": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+					},
+				},
+			},
+
+			//
+			//
+
+			"server_types": &schema.Schema{
+				Type: schema.TypeList,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"type": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"cpu": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"memory": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"server_groups": &schema.Schema{
+							Type: schema.TypeList,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+							Computed: true,
+						},
+						"price_class": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+				Computed: true,
+			},
+			"types": &schema.Schema{
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				Computed: true,
+			},
+		},
+	}
+}
+
+func dataSourceServerTypesRead(d *schema.ResourceData, m interface{}) error {
+
+	result := make([]map[string]interface{}, 0)
+	names := make([]string, 0)
+	for _, t := range getServerTypes() {
+		match := true
+		for _, f := range d.Get("filter").([]interface{}) {
+			filter := f.(map[string]interface{})
+			switch filter["name"] {
+			case "type":
+				matched, _ := regexp.MatchString(filter["value"].(string), t.Type)
+				match = match && matched
+			case "cpu":
+				match = match && filter["value"] == t.CPU
+			case "memory":
+				match = match && filter["value"] == t.Memory
+			case "server_group":
+				group := false
+				for _, g := range t.ServerGroups {
+					group = group || filter["value"] == g
+				}
+				match = match && group
+			case "price_class":
+				match = match && filter["value"] == t.PriceClass
+			default:
+				log.Printf("[ERROR] filter by '%s' not supported", filter["name"])
+				return errors.New("invalid filter")
+			}
+		}
+		if !match {
+			continue
+		}
+		result = append(result, map[string]interface{}{
+			"type":          t.Type,
+			"cpu":           t.CPU,
+			"memory":        t.Memory,
+			"server_groups": t.ServerGroups,
+			"price_class":   t.PriceClass,
+		})
+		names = append(names, t.Type)
+	}
+
+	if err := d.Set("server_types", result); err != nil {
+		return err
+	}
+	d.Set("types", names)
+
+	sorted := append([]string{}, names...)
+	sort.Strings(sorted)
+	d.SetId(fmt.Sprintf("%d", hashcode.String(strings.Join(sorted, ","))))
+
+	return nil
+}
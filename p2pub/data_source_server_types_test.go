@@ -0,0 +1,34 @@
+package p2pub
+
+import (
+	"testing"
+)
+
+func TestServerTypes(t *testing.T) {
+	seen := map[string]bool{}
+	for _, st := range getServerTypes() {
+		if st.CPU == "" || st.Memory == "" || st.PriceClass == "" || len(st.ServerGroups) == 0 {
+			t.Fatalf("incomplete server type: %v", st)
+		}
+		if seen[st.Type] {
+			t.Fatalf("duplicated server type: %s", st.Type)
+		}
+		seen[st.Type] = true
+	}
+	if findServerType("VG4-8") == nil || findServerType("VG4-8").PriceClass != "Guarantee" {
+		t.Fatalf("VG4-8 should be a guaranteed type")
+	}
+	if findServerType("VB0-l") != nil {
+		t.Fatalf("VB0-l should not be found")
+	}
+}
+
+func TestValidateServerType(t *testing.T) {
+	if warns, errs := validateServerType("VB0-1", "type"); len(warns) != 0 || len(errs) != 0 {
+		t.Fatalf("VB0-1 should be valid: %v %v", warns, errs)
+	}
+	// unknown types are only warned, since the catalog may be behind P2PUB
+	if warns, errs := validateServerType("VB0-l", "type"); len(warns) == 0 || len(errs) != 0 {
+		t.Fatalf("VB0-l should be warned: %v %v", warns, errs)
+	}
+}
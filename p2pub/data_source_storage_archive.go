@@ -0,0 +1,80 @@
+package p2pub
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func dataSourceStorageArchive() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceStorageArchiveRead,
+
+		Timeouts: &schema.ResourceTimeout{
+			Default: schema.DefaultTimeout(5 * time.Minute),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"service_code": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+
+			//
+			//
+
+			"archive_size": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"used_size": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"free_size": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"image_count": &schema.Schema{
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"image_list": &schema.Schema{
+				Type: schema.TypeList,
+				Elem: &schema.Resource{
+					Schema: archivedImageSchema(),
+				},
+				Computed: true,
+			},
+		},
+	}
+}
+
+func dataSourceStorageArchiveRead(d *schema.ResourceData, m interface{}) error {
+
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+
+	iar := d.Get("service_code").(string)
+	if iar == "" {
+		contract, err := getContract(api, gis)
+		if err != nil {
+			return err
+		}
+		if contract.StorageArchive.ServiceCode == "" {
+			return errors.New("cannot find storage archive contract")
+		}
+		iar = contract.StorageArchive.ServiceCode
+	}
+
+	if err := readStorageArchiveContents(d, api, gis, iar); err != nil {
+		return err
+	}
+
+	d.SetId(iar)
+	d.Set("service_code", iar)
+
+	return nil
+}
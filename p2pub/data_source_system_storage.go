@@ -1,6 +1,7 @@
 package p2pub
 
 import (
+	"fmt"
 	"time"
 	"log"
 	"regexp"
@@ -89,11 +90,82 @@ func dataSourceSystemStorage() *schema.Resource {
 				Type:     schema.TypeString,
 				Optional: true,
 				Computed: true,
-			},			
+			},
+			"attached_virtual_server": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+			"pci_slot": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+			"resource_status": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+			"contract_status": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
 		},
 	}
 }
 
+// systemStorageFilterFields returns the attributes of each storage in the
+// list, which filters are compared with
+func systemStorageFilterFields(storages *protocol.SystemStorageListGetResponse) []map[string]string {
+	fields := make([]map[string]string, 0)
+	for _, storage := range storages.SystemStorageList {
+		fields = append(fields, map[string]string{
+			"service_code":            storage.ServiceCode,
+			"os_type":                 storage.OSType,
+			"label":                   storage.Label,
+			"type":                    storage.Type,
+			"attached":                fmt.Sprintf("%v", storage.ResourceStatus == p2pubapi.Attached.String()),
+			"attached_virtual_server": storage.AttachedVirtualServer.ServiceCode,
+			"resource_status":         storage.ResourceStatus,
+			"contract_status":         storage.ContractStatus,
+		})
+	}
+	return fields
+}
+
+// filterStorages returns the index of the storage with serviceCode, or
+// otherwise the indexes of the storages matching all filters. label is
+// matched as a regular expression
+func filterStorages(storages []map[string]string, serviceCode string, filters []interface{}) ([]int, error) {
+	var matches []int
+	for idx, storage := range storages {
+		if serviceCode != "" && serviceCode == storage["service_code"] {
+			return []int{ idx }, nil
+		}
+		match := true
+		for _, f := range filters {
+			filter := f.(map[string]interface{})
+			name := filter["name"].(string)
+			value, ok := storage[name]
+			if !ok || name == "service_code" {
+				log.Printf("[ERROR] filter by '%s' not supported", name)
+				return nil, errors.New("invalid filter")
+			}
+			if name == "label" {
+				matched , _ := regexp.MatchString(filter["value"].(string), value)
+				match = match && matched
+			} else {
+				match = match && filter["value"] == value
+			}
+		}
+		if match {
+			matches = append(matches, idx)
+		}
+	}
+	return matches, nil
+}
+
 func getSystemStorageList(api *p2pubapi.API, gis string) (*protocol.SystemStorageListGetResponse, error) {
 	args := protocol.SystemStorageListGet{
 		GisServiceCode: gis,
@@ -119,31 +191,9 @@ func dataSourceSystemStorageRead(d *schema.ResourceData, m interface{}) error {
 		return err
 	}
 
-	var matches []int
-	for idx, storage := range storages.SystemStorageList {
-		if d.Get("service_code") == storage.ServiceCode {
-			matches = []int{ idx }
-			break
-		}
-		match := true
-		for _, f := range d.Get("filter").([]interface{}) {
-			filter := f.(map[string]interface{})
-			switch filter["name"] {
-			case "os_type":
-				match = match && filter["value"] == storage.OSType
-			case "label":
-				matched , _ := regexp.MatchString(filter["value"].(string), storage.Label)
-				match = match && matched
-			case "type":
-				match = match && filter["value"] == storage.Type
-			default:
-				log.Printf("[ERROR] filter by '%s' not supported", filter["name"])
-				return errors.New("invalid filter")
-			}
-		}
-		if match {
-			matches = append(matches, idx)
-		}
+	matches, err := filterStorages(systemStorageFilterFields(storages), d.Get("service_code").(string), d.Get("filter").([]interface{}))
+	if err != nil {
+		return err
 	}
 
 	if len(matches) == 0 {
@@ -177,6 +227,19 @@ func dataSourceSystemStorageRead(d *schema.ResourceData, m interface{}) error {
 	d.Set("service_code", ans.ServiceCode)
 	d.Set("encryption", ans.Encryption)
 	d.Set("mode", ans.Mode)
+	d.Set("resource_status", ans.ResourceStatus)
+	d.Set("contract_status", ans.ContractStatus)
+
+	ivm := ""
+	pci := ""
+	if ans.ResourceStatus == p2pubapi.Attached.String() {
+		ivm = ans.AttachedVirtualServer.ServiceCode
+		if pci, err = findStoragePciSlot(api, gis, ivm, ans.ServiceCode, true); err != nil {
+			return err
+		}
+	}
+	d.Set("attached_virtual_server", ivm)
+	d.Set("pci_slot", pci)
 	
 	return nil
 }
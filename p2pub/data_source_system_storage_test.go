@@ -0,0 +1,42 @@
+package p2pub
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterStorages(t *testing.T) {
+	storages := []map[string]string{
+		{"service_code": "iba00000001", "label": "web-1", "attached": "true", "attached_virtual_server": "ivm00000001", "resource_status": "Attached", "contract_status": "InService"},
+		{"service_code": "iba00000002", "label": "web-2", "attached": "false", "attached_virtual_server": "", "resource_status": "NotAttached", "contract_status": "InService"},
+		{"service_code": "iba00000003", "label": "db-1", "attached": "false", "attached_virtual_server": "", "resource_status": "NotAttached", "contract_status": "InService"},
+	}
+	filter := func(name, value string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "value": value}
+	}
+
+	cases := []struct {
+		serviceCode string
+		filters     []interface{}
+		matches     []int
+	}{
+		{"", []interface{}{filter("attached", "false")}, []int{1, 2}},
+		{"", []interface{}{filter("attached", "false"), filter("label", "^web-")}, []int{1}},
+		{"", []interface{}{filter("attached_virtual_server", "ivm00000001")}, []int{0}},
+		{"", []interface{}{filter("resource_status", "NotAttached"), filter("contract_status", "InService")}, []int{1, 2}},
+		{"iba00000003", []interface{}{filter("attached", "true")}, []int{2}},
+	}
+	for _, c := range cases {
+		matches, err := filterStorages(storages, c.serviceCode, c.filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(matches, c.matches) {
+			t.Fatalf("unexpected matches for %v: %v", c.filters, matches)
+		}
+	}
+
+	if _, err := filterStorages(storages, "", []interface{}{filter("pci_slot", "1")}); err == nil {
+		t.Fatalf("unknown filter should be rejected")
+	}
+}
@@ -0,0 +1,240 @@
+package p2pub
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/hashcode"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+type storageType struct {
+	Type          string
+	Size          string
+	OS            string
+	OSType        string
+	Encryption    bool
+	StorageGroups []string
+}
+
+// storage groups of P2PUB. the catalog does not record which groups offer
+// each type, so every type lists both
+var storageGroups = []string{"A", "B"}
+
+// system storage types available on P2PUB. see http://manual.iij.jp/p2/pubapi/59949023.html
+var systemStorageTypes = []string{
+	"S30GB_CENTOS6_64", "S30GB_CENTOS7_64", "S30GB_RHEL7_64",
+	"S30GB_UBUNTU16_64", "S30GB_UBUNTU18_64", "S30GB_DEBIAN9_64",
+	"S40GB_WIN2012R2_64", "S40GB_WIN2016_64",
+	"SX30GB_CENTOS7_64", "SX30GB_RHEL7_64", "SX30GB_UBUNTU16_64", "SX30GB_UBUNTU18_64",
+	"SX40GB_WIN2012R2_64", "SX40GB_WIN2016_64",
+}
+
+var systemStorageTypePattern = regexp.MustCompile(`^(SX|S)(\d+)GB_(.+)$`)
+
+// systemStorageOSType returns "Windows" or "Linux" from the name of a system
+// storage type, which needs not be in the catalog. "" for unknown names
+func systemStorageOSType(stype string) string {
+	m := systemStorageTypePattern.FindStringSubmatch(stype)
+	if m == nil {
+		return ""
+	}
+	if strings.HasPrefix(m[3], "WIN") {
+		return "Windows"
+	}
+	return "Linux"
+}
+
+func getSystemStorageTypes() []storageType {
+	types := make([]storageType, 0)
+	for _, t := range systemStorageTypes {
+		m := systemStorageTypePattern.FindStringSubmatch(t)
+		if m == nil {
+			continue
+		}
+		types = append(types, storageType{
+			Type:          t,
+			Size:          m[2],
+			OS:            m[3],
+			OSType:        systemStorageOSType(t),
+			Encryption:    isExtendedSystemStorage(t),
+			StorageGroups: storageGroups,
+		})
+	}
+	return types
+}
+
+func findStorageType(types []storageType, stype string) *storageType {
+	for _, t := range types {
+		if t.Type == stype {
+			return &t
+		}
+	}
+	return nil
+}
+
+// validateStorageTypeDiff checks the combination of type and encryption
+// against the catalog. storage_group is left to the API, as the catalog does
+// not know which groups offer each type. types missing from the catalog are
+// left to the API as well
+func validateStorageTypeDiff(d *schema.ResourceDiff, types []storageType) error {
+	stype := d.Get("type").(string)
+	if stype == "" {
+		// not known yet
+		return nil
+	}
+	t := findStorageType(types, stype)
+	if t == nil {
+		log.Printf("[WARN] p2pub: storage type %s is not in the catalog", stype)
+		return nil
+	}
+
+	// "No" is accepted for standard types, which are not encrypted anyway
+	if d.HasChange("encryption") || d.HasChange("type") {
+		if d.Get("encryption").(string) == "Yes" && !t.Encryption {
+			return fmt.Errorf("encryption is not supported by storage type %s", stype)
+		}
+	}
+
+	return nil
+}
+
+func storageTypesSchema() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		"filter": &schema.Schema{
+			Type:     schema.TypeList,
+			Optional: true,
+			Elem: &schema.Resource{
+				Schema: map[string]*schema.Schema{
+					"name": &schema.Schema{
+						Type:     schema.TypeString,
+						Required: true,
+					},
+					"value": &schema.Schema{
+						Type:     schema.TypeString,
+						Required: true,
+					},
+				},
+			},
+		},
+
+		//
+		//
+
+		"storage_types": &schema.Schema{
+			Type: schema.TypeList,
+			Elem: &schema.Resource{
+				Schema: map[string]*schema.Schema{
+					"type": &schema.Schema{
+						Type:     schema.TypeString,
+						Computed: true,
+					},
+					"storage_size": &schema.Schema{
+						Type:     schema.TypeString,
+						Computed: true,
+					},
+					"os": &schema.Schema{
+						Type:     schema.TypeString,
+						Computed: true,
+					},
+					"os_type": &schema.Schema{
+						Type:     schema.TypeString,
+						Computed: true,
+					},
+					"encryption": &schema.Schema{
+						Type:     schema.TypeBool,
+						Computed: true,
+					},
+					"storage_groups": &schema.Schema{
+						Type: schema.TypeList,
+						Elem: &schema.Schema{
+							Type: schema.TypeString,
+						},
+						Computed: true,
+					},
+				},
+			},
+			Computed: true,
+		},
+		"types": &schema.Schema{
+			Type: schema.TypeList,
+			Elem: &schema.Schema{
+				Type: schema.TypeString,
+			},
+			Computed: true,
+		},
+	}
+}
+
+func readStorageTypes(d *schema.ResourceData, types []storageType) error {
+
+	result := make([]map[string]interface{}, 0)
+	names := make([]string, 0)
+	for _, t := range types {
+		match := true
+		for _, f := range d.Get("filter").([]interface{}) {
+			filter := f.(map[string]interface{})
+			switch filter["name"] {
+			case "type":
+				matched, _ := regexp.MatchString(filter["value"].(string), t.Type)
+				match = match && matched
+			case "storage_size":
+				match = match && filter["value"] == t.Size
+			case "os":
+				matched, _ := regexp.MatchString(filter["value"].(string), t.OS)
+				match = match && matched
+			case "os_type":
+				match = match && filter["value"] == t.OSType
+			case "encryption":
+				match = match && filter["value"] == fmt.Sprintf("%v", t.Encryption)
+			case "storage_group":
+				group := false
+				for _, g := range t.StorageGroups {
+					group = group || filter["value"] == g
+				}
+				match = match && group
+			default:
+				log.Printf("[ERROR] filter by '%s' not supported", filter["name"])
+				return errors.New("invalid filter")
+			}
+		}
+		if !match {
+			continue
+		}
+		result = append(result, map[string]interface{}{
+			"type":           t.Type,
+			"storage_size":   t.Size,
+			"os":             t.OS,
+			"os_type":        t.OSType,
+			"encryption":     t.Encryption,
+			"storage_groups": t.StorageGroups,
+		})
+		names = append(names, t.Type)
+	}
+
+	if err := d.Set("storage_types", result); err != nil {
+		return err
+	}
+	d.Set("types", names)
+
+	sorted := append([]string{}, names...)
+	sort.Strings(sorted)
+	d.SetId(fmt.Sprintf("%d", hashcode.String(strings.Join(sorted, ","))))
+
+	return nil
+}
+
+func dataSourceSystemStorageTypes() *schema.Resource {
+	return &schema.Resource{
+		Read:   dataSourceSystemStorageTypesRead,
+		Schema: storageTypesSchema(),
+	}
+}
+
+func dataSourceSystemStorageTypesRead(d *schema.ResourceData, m interface{}) error {
+	return readStorageTypes(d, getSystemStorageTypes())
+}
@@ -0,0 +1,54 @@
+package p2pub
+
+import (
+	"testing"
+)
+
+func TestSystemStorageTypes(t *testing.T) {
+	types := getSystemStorageTypes()
+	if len(types) != len(systemStorageTypes) {
+		t.Fatalf("unexpected number of storage types: %d", len(types))
+	}
+
+	st := findStorageType(types, "SX40GB_WIN2016_64")
+	if st == nil {
+		t.Fatalf("SX40GB_WIN2016_64 not found")
+	}
+	if st.Size != "40" || st.OS != "WIN2016_64" || st.OSType != "Windows" || !st.Encryption {
+		t.Fatalf("unexpected storage type: %v", st)
+	}
+
+	st = findStorageType(types, "S30GB_CENTOS7_64")
+	if st == nil {
+		t.Fatalf("S30GB_CENTOS7_64 not found")
+	}
+	if st.OSType != "Linux" || st.Encryption {
+		t.Fatalf("unexpected storage type: %v", st)
+	}
+}
+
+func TestAdditionalStorageTypes(t *testing.T) {
+	types := getAdditionalStorageTypes()
+	if len(types) != len(additionalStorageTypes) {
+		t.Fatalf("unexpected number of storage types: %d", len(types))
+	}
+	if st := findStorageType(types, "BX500GB"); st == nil || st.Size != "500" || !st.Encryption {
+		t.Fatalf("unexpected storage type: %v", st)
+	}
+	if st := findStorageType(types, "B1000GB"); st == nil || st.Encryption {
+		t.Fatalf("unexpected storage type: %v", st)
+	}
+}
+
+func TestSystemStorageOSType(t *testing.T) {
+	// types missing from the catalog are told from their names
+	if os := systemStorageOSType("SX60GB_WIN2019_64"); os != "Windows" {
+		t.Fatalf("unexpected os type: %s", os)
+	}
+	if os := systemStorageOSType("S30GB_CENTOS8_64"); os != "Linux" {
+		t.Fatalf("unexpected os type: %s", os)
+	}
+	if os := systemStorageOSType("X1"); os != "" {
+		t.Fatalf("unexpected os type: %s", os)
+	}
+}
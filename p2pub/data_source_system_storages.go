@@ -0,0 +1,77 @@
+package p2pub
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/hashcode"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// p2pub_system_storages lists the service codes of all system storages
+// matching the filters, while p2pub_system_storage picks a single one
+func dataSourceSystemStorages() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceSystemStoragesRead,
+
+		Schema: map[string]*schema.Schema{
+			"filter": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"value": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+					},
+				},
+			},
+
+			//
+			//
+
+			"service_code_list": &schema.Schema{
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				Computed: true,
+			},
+		},
+	}
+}
+
+func dataSourceSystemStoragesRead(d *schema.ResourceData, m interface{}) error {
+
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+
+	storages, err := getSystemStorageList(api, gis)
+	if err != nil {
+		return err
+	}
+
+	fields := systemStorageFilterFields(storages)
+	matches, err := filterStorages(fields, "", d.Get("filter").([]interface{}))
+	if err != nil {
+		return err
+	}
+
+	codes := make([]string, 0)
+	for _, idx := range matches {
+		codes = append(codes, fields[idx]["service_code"])
+	}
+	d.Set("service_code_list", codes)
+
+	sorted := append([]string{}, codes...)
+	sort.Strings(sorted)
+	d.SetId(fmt.Sprintf("%d", hashcode.String(strings.Join(sorted, ","))))
+
+	return nil
+}
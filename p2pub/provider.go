@@ -1,6 +1,9 @@
 package p2pub
 
 import (
+	"sync"
+	"time"
+
 	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/iij/p2pubapi"
 )
@@ -8,6 +11,19 @@ import (
 type Context struct {
 	API            *p2pubapi.API
 	GisServiceCode string
+	// default time to wait for OS shutdown before power off
+	ShutdownTimeout time.Duration
+
+	// power holds of virtual servers, keyed by ivm service code
+	powerHoldsMutex sync.Mutex
+	powerHolds      map[string]*vmPowerHold
+	// shutdown timeouts of virtual server resources, keyed by ivm service
+	// code and guarded by powerHoldsMutex
+	vmShutdownTimeouts map[string]time.Duration
+
+	// locks serialising changes of load balancers, keyed by ifl service code
+	lbLocksMutex sync.Mutex
+	lbLocks      map[string]*sync.Mutex
 }
 
 func Provider() *schema.Provider {
@@ -37,28 +53,52 @@ func Provider() *schema.Provider {
 				Description: "",
 				Default:     "p2pub.api.iij.jp",
 			},
+			"shutdown_timeout": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "",
+				DefaultFunc:  schema.EnvDefaultFunc("P2PUB_SHUTDOWN_TIMEOUT", "5m"),
+				ValidateFunc: validateDuration,
+			},
 		},
 		ResourcesMap: map[string]*schema.Resource{
-			"p2pub_virtual_server":     resourceVirtualServer(),
-			"p2pub_system_storage":     resourceSystemStorage(),
-			"p2pub_additional_storage": resourceAdditionalStorage(),
-			"p2pub_storage_archive":    resourceStorageArchive(),
-			"p2pub_global_ip_address":  resourceGlobalIPAddress(),
-			"p2pub_private_network":    resourcePrivateNetwork(),
-			"p2pub_load_balancer":      resourceLoadBalancer(),
+			"p2pub_virtual_server":             resourceVirtualServer(),
+			"p2pub_system_storage":             resourceSystemStorage(),
+			"p2pub_additional_storage":         resourceAdditionalStorage(),
+			"p2pub_storage_attachment":         resourceStorageAttachment(),
+			"p2pub_storage_archive":            resourceStorageArchive(),
+			"p2pub_storage_archive_policy":     resourceStorageArchivePolicy(),
+			"p2pub_global_ip_address":          resourceGlobalIPAddress(),
+			"p2pub_global_ip_assignment":       resourceGlobalIPAssignment(),
+			"p2pub_private_network":            resourcePrivateNetwork(),
+			"p2pub_private_network_attachment": resourcePrivateNetworkAttachment(),
+			"p2pub_load_balancer":              resourceLoadBalancer(),
+			"p2pub_lb_traffic_ip":              resourceLBTrafficIP(),
+			"p2pub_placement_group":            resourcePlacementGroup(),
 		},
 		DataSourcesMap: map[string]*schema.Resource{
-			"p2pub_custom_os_image":    dataSourceCustomOSImage(),
-			"p2pub_virtual_server":     dataSourceVirtualServer(),
-			"p2pub_system_storage":     dataSourceSystemStorage(),
-			"p2pub_additional_storage": dataSourceAdditionalStorage(),
-			"p2pub_load_balancer":      dataSourceLoadBalancer(),
+			"p2pub_custom_os_image":          dataSourceCustomOSImage(),
+			"p2pub_virtual_server":           dataSourceVirtualServer(),
+			"p2pub_system_storage":           dataSourceSystemStorage(),
+			"p2pub_additional_storage":       dataSourceAdditionalStorage(),
+			"p2pub_system_storages":          dataSourceSystemStorages(),
+			"p2pub_additional_storages":      dataSourceAdditionalStorages(),
+			"p2pub_load_balancer":            dataSourceLoadBalancer(),
+			"p2pub_server_types":             dataSourceServerTypes(),
+			"p2pub_system_storage_types":     dataSourceSystemStorageTypes(),
+			"p2pub_additional_storage_types": dataSourceAdditionalStorageTypes(),
+			"p2pub_storage_archive":          dataSourceStorageArchive(),
 		},
 		ConfigureFunc: func(d *schema.ResourceData) (interface{}, error) {
 			api := p2pubapi.NewAPI(d.Get("access_key_id").(string), d.Get("secret_access_key").(string))
+			shutdownTimeout, err := time.ParseDuration(d.Get("shutdown_timeout").(string))
+			if err != nil {
+				return nil, err
+			}
 			context := &Context{
-				API:            api,
-				GisServiceCode: d.Get("gis_service_code").(string),
+				API:             api,
+				GisServiceCode:  d.Get("gis_service_code").(string),
+				ShutdownTimeout: shutdownTimeout,
 			}
 			return context, nil
 		},
@@ -1,10 +1,15 @@
 package p2pub
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
 	"github.com/iij/p2pubapi"
 	"github.com/iij/p2pubapi/protocol"
 )
@@ -17,7 +22,8 @@ func resourceAdditionalStorage() *schema.Resource {
 		Delete: resourceAdditionalStorageDelete,
 
 		Timeouts: &schema.ResourceTimeout{
-			Create:  schema.DefaultTimeout(5 * time.Minute),
+			Create:  schema.DefaultTimeout(30 * time.Minute),
+			Update:  schema.DefaultTimeout(30 * time.Minute),
 			Default: schema.DefaultTimeout(5 * time.Minute),
 		},
 
@@ -25,6 +31,8 @@ func resourceAdditionalStorage() *schema.Resource {
 			State: schema.ImportStatePassthrough,
 		},
 
+		CustomizeDiff: resourceAdditionalStorageCustomizeDiff,
+
 		Schema: map[string]*schema.Schema{
 			"type": &schema.Schema{
 				Type:     schema.TypeString,
@@ -50,15 +58,51 @@ func resourceAdditionalStorage() *schema.Resource {
 				Optional: true,
 				Computed: true,
 			},
+			// "Yes" encrypts a BX/GX storage on creation
 			"encryption": &schema.Schema{
-				Type:     schema.TypeString,
-				Optional: true,
-				Computed: true,
+				Type:             schema.TypeString,
+				Optional:         true,
+				Computed:         true,
+				ForceNew:         true,
+				ValidateFunc:     validation.StringInSlice([]string{"Yes", "No"}, false),
+				DiffSuppressFunc: suppressStandardStorageEncryptionDiff(isExtendedAdditionalStorage),
 			},
 			"mode": &schema.Schema{
-				Type:     schema.TypeString,
-				Optional: true,
-				Computed: true,
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				ValidateFunc: validation.StringInSlice([]string{"ReadWrite", "ReadOnly"}, false),
+			},
+
+			//
+
+			"source_image": &schema.Schema{
+				Type: schema.TypeMap,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"gis_service_code": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"iar_service_code": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"image_id": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+					},
+				},
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"source_storage"},
+			},
+			"source_storage": &schema.Schema{
+				Type:          schema.TypeString,
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"source_image"},
 			},
 		},
 	}
@@ -93,6 +137,101 @@ func setAdditionalStorageLabel(api *p2pubapi.API, gis, ib, label string) error {
 	return nil
 }
 
+func changeAdditionalStorageType(api *p2pubapi.API, gis, ib, stype string, timeout time.Duration) error {
+	info, err := getAdditionalStorageInfo(api, gis, ib)
+	if err != nil {
+		return err
+	}
+	attachStatus := p2pubapi.NotAttached
+	if info.ResourceStatus == p2pubapi.Attached.String() {
+		attachStatus = p2pubapi.Attached
+	}
+	args := protocol.StorageItemChange{
+		GisServiceCode:     gis,
+		StorageServiceCode: ib,
+		Type:               stype,
+	}
+	var res = protocol.StorageItemChangeResponse{}
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return err
+	}
+	if err := p2pubapi.WaitDataStorage(api, gis, ib,
+		p2pubapi.InService, attachStatus, timeout); err != nil {
+		return err
+	}
+	return nil
+}
+
+func setAdditionalStorageMode(api *p2pubapi.API, gis, ib, mode string, timeout time.Duration) error {
+	info, err := getAdditionalStorageInfo(api, gis, ib)
+	if err != nil {
+		return err
+	}
+	attachStatus := p2pubapi.NotAttached
+	if info.ResourceStatus == p2pubapi.Attached.String() {
+		attachStatus = p2pubapi.Attached
+	}
+	args := protocol.StorageModeSet{
+		GisServiceCode:     gis,
+		StorageServiceCode: ib,
+		Mode:               mode,
+	}
+	var res = protocol.StorageModeSetResponse{}
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return err
+	}
+	if err := p2pubapi.WaitDataStorage(api, gis, ib,
+		p2pubapi.InService, attachStatus, timeout); err != nil {
+		return err
+	}
+	return nil
+}
+
+func restoreDataStorage(api *p2pubapi.API, gis, ib, iar, id string, timeout time.Duration) error {
+	args := protocol.Restore{
+		GisServiceCode:     gis,
+		StorageServiceCode: ib,
+		IarServiceCode:     iar,
+		Image:              "Archive",
+		ImageId:            id,
+	}
+	var res = protocol.RestoreResponse{}
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return err
+	}
+	if err := p2pubapi.WaitDataStorage(api, gis, ib,
+		p2pubapi.InService, p2pubapi.NotAttached, timeout); err != nil {
+		return err
+	}
+	return nil
+}
+
+func cloneDataStorage(api *p2pubapi.API, gis, ib, src string, timeout time.Duration) error {
+	info, err := getAdditionalStorageInfo(api, gis, src)
+	if err != nil {
+		return err
+	}
+	if info.ResourceStatus == p2pubapi.Attached.String() {
+		log.Printf("[WARN] p2pub: %s - source storage %s is attached to %s. the copy may be inconsistent",
+			ib, src, info.AttachedVirtualServer.ServiceCode)
+	}
+	args := protocol.Restore{
+		GisServiceCode:        gis,
+		StorageServiceCode:    ib,
+		Image:                 "Storage",
+		SrcStorageServiceCode: src,
+	}
+	var res = protocol.RestoreResponse{}
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return err
+	}
+	if err := p2pubapi.WaitDataStorage(api, gis, ib,
+		p2pubapi.InService, p2pubapi.NotAttached, timeout); err != nil {
+		return err
+	}
+	return nil
+}
+
 func isExtendedAdditionalStorage(stype string) bool {
 	if strings.Index(stype, "BX") == 0 || strings.Index(stype, "GX") == 0 {
 		return true
@@ -101,6 +240,66 @@ func isExtendedAdditionalStorage(stype string) bool {
 	return false
 }
 
+// additionalStorageTypeChangeable reports whether the item change API can
+// convert storage type from to to. only upgrades within the same family
+// (e.g. B100GB -> B500GB) are possible
+func additionalStorageTypeChangeable(from, to string) bool {
+	f := additionalStorageTypePattern.FindStringSubmatch(from)
+	t := additionalStorageTypePattern.FindStringSubmatch(to)
+	if f == nil || t == nil || f[1] != t[1] {
+		return false
+	}
+	fsize, _ := strconv.Atoi(f[2])
+	tsize, _ := strconv.Atoi(t[2])
+	return tsize > fsize
+}
+
+func resourceAdditionalStorageCustomizeDiff(d *schema.ResourceDiff, m interface{}) error {
+	if err := validateStorageTypeDiff(d, getAdditionalStorageTypes()); err != nil {
+		return err
+	}
+
+	stype := d.Get("type").(string)
+	if stype == "" {
+		// not known yet
+		return nil
+	}
+	t := findStorageType(getAdditionalStorageTypes(), stype)
+
+	// storage_size follows type
+	if t != nil && d.HasChange("storage_size") && d.NewValueKnown("storage_size") {
+		if size := d.Get("storage_size").(string); size != "" && size != t.Size {
+			return fmt.Errorf("storage_size %s does not match storage type %s. change type instead", size, stype)
+		}
+	}
+
+	if t != nil && d.Id() == "" && d.NewValueKnown("source_storage") && d.Get("source_storage").(string) != "" && m != nil {
+		// the copy must fit into the new storage
+		src, err := getAdditionalStorageInfo(m.(*Context).API, m.(*Context).GisServiceCode, d.Get("source_storage").(string))
+		if err != nil {
+			return err
+		}
+		src_size, _ := strconv.Atoi(src.StorageSize)
+		dst_size, _ := strconv.Atoi(t.Size)
+		if src_size > dst_size {
+			return fmt.Errorf("source storage %s (%sGB) does not fit into storage type %s", d.Get("source_storage"), src.StorageSize, stype)
+		}
+	}
+
+	if d.Id() == "" || !d.HasChange("type") {
+		return nil
+	}
+
+	o, _ := d.GetChange("type")
+	if !additionalStorageTypeChangeable(o.(string), stype) {
+		return d.ForceNew("type")
+	}
+	if t == nil {
+		return d.SetNewComputed("storage_size")
+	}
+	return d.SetNew("storage_size", t.Size)
+}
+
 //
 // resource operations
 //
@@ -132,10 +331,36 @@ func resourceAdditionalStorageCreate(d *schema.ResourceData, m interface{}) erro
 
 	ib := res.ServiceCode
 
+	// a restore from an archive or a clone of another storage can run for
+	// long and fail halfway, so the state owns the storage before they start
+	d.SetId(ib)
+	d.Partial(true)
+
 	if err := p2pubapi.WaitDataStorage(api, gis, ib,
 		p2pubapi.InService, p2pubapi.NotAttached, d.Timeout(schema.TimeoutCreate)); err != nil {
 		return err
 	}
+	d.SetPartial("type")
+	d.SetPartial("storage_group")
+	d.SetPartial("encryption")
+
+	if d.Get("source_image") != nil && len(d.Get("source_image").(map[string]interface{})) != 0 {
+		src_gis := d.Get("source_image.gis_service_code").(string)
+		src_iar := d.Get("source_image.iar_service_code").(string)
+		image_id := d.Get("source_image.image_id").(string)
+		if src_gis != gis {
+			return errors.New("Inter-contract image restore is currently not supported.")
+		}
+		if err := restoreDataStorage(api, gis, ib, src_iar, image_id, d.Timeout(schema.TimeoutCreate)); err != nil {
+			return err
+		}
+	}
+
+	if d.Get("source_storage") != nil && d.Get("source_storage").(string) != "" {
+		if err := cloneDataStorage(api, gis, ib, d.Get("source_storage").(string), d.Timeout(schema.TimeoutCreate)); err != nil {
+			return err
+		}
+	}
 
 	if d.Get("label") != nil && d.Get("label").(string) != "" {
 		if err := setAdditionalStorageLabel(api, gis, ib, d.Get("label").(string)); err != nil {
@@ -143,7 +368,13 @@ func resourceAdditionalStorageCreate(d *schema.ResourceData, m interface{}) erro
 		}
 	}
 
-	d.SetId(ib)
+	if d.Get("mode").(string) != "" {
+		if err := setAdditionalStorageMode(api, gis, ib, d.Get("mode").(string), d.Timeout(schema.TimeoutCreate)); err != nil {
+			return err
+		}
+	}
+
+	d.Partial(false)
 
 	return resourceAdditionalStorageRead(d, m)
 }
@@ -165,6 +396,7 @@ func resourceAdditionalStorageRead(d *schema.ResourceData, m interface{}) error
 	d.Set("label", res.Label)
 	d.Set("mode", res.Mode)
 
+	// B/G types have no encryption in the response
 	if isExtendedAdditionalStorage(res.Type) {
 		d.Set("encryption", res.Encryption)
 	}
@@ -179,6 +411,14 @@ func resourceAdditionalStorageUpdate(d *schema.ResourceData, m interface{}) erro
 
 	d.Partial(true)
 
+	if d.HasChange("type") {
+		if err := changeAdditionalStorageType(api, gis, d.Id(), d.Get("type").(string), d.Timeout(schema.TimeoutUpdate)); err != nil {
+			return err
+		}
+		d.SetPartial("type")
+		d.SetPartial("storage_size")
+	}
+
 	if d.HasChange("label") {
 		if err := setAdditionalStorageLabel(api, gis, d.Id(), d.Get("label").(string)); err != nil {
 			return err
@@ -186,9 +426,29 @@ func resourceAdditionalStorageUpdate(d *schema.ResourceData, m interface{}) erro
 		d.SetPartial("label")
 	}
 
+	if d.HasChange("mode") {
+		info, err := getAdditionalStorageInfo(api, gis, d.Id())
+		if err != nil {
+			return err
+		}
+		set_mode := func() error {
+			return setAdditionalStorageMode(api, gis, d.Id(), d.Get("mode").(string), d.Timeout(schema.TimeoutUpdate))
+		}
+		// an attached storage changes mode while its VM is powered off
+		if info.ResourceStatus == p2pubapi.Attached.String() {
+			err = withVMStopped(m.(*Context), info.AttachedVirtualServer.ServiceCode, d.Timeout(schema.TimeoutUpdate), set_mode)
+		} else {
+			err = set_mode()
+		}
+		if err != nil {
+			return err
+		}
+		d.SetPartial("mode")
+	}
+
 	d.Partial(false)
 
-	return nil
+	return resourceAdditionalStorageRead(d, m)
 }
 
 func resourceAdditionalStorageDelete(d *schema.ResourceData, m interface{}) error {
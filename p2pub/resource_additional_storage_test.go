@@ -0,0 +1,25 @@
+package p2pub
+
+import (
+	"testing"
+)
+
+func TestAdditionalStorageTypeChangeable(t *testing.T) {
+	cases := []struct {
+		from, to string
+		expected bool
+	}{
+		{"B100GB", "B500GB", true},
+		{"BX500GB", "BX2000GB", true},
+		{"B500GB", "B100GB", false},
+		{"B100GB", "B100GB", false},
+		{"B100GB", "BX500GB", false},
+		{"G100GB", "B500GB", false},
+		{"B100GB", "unknown", false},
+	}
+	for _, c := range cases {
+		if got := additionalStorageTypeChangeable(c.from, c.to); got != c.expected {
+			t.Errorf("%s -> %s: expected %v, got %v", c.from, c.to, c.expected, got)
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package p2pub
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/iij/p2pubapi"
 	"github.com/iij/p2pubapi/protocol"
@@ -13,6 +18,13 @@ func resourceGlobalIPAddress() *schema.Resource {
 		Update: resourceGlobalIPAddressUpdate,
 		Delete: resourceGlobalIPAddressDelete,
 
+		Timeouts: &schema.ResourceTimeout{
+			Create:  schema.DefaultTimeout(10 * time.Minute),
+			Update:  schema.DefaultTimeout(10 * time.Minute),
+			Delete:  schema.DefaultTimeout(10 * time.Minute),
+			Default: schema.DefaultTimeout(10 * time.Minute),
+		},
+
 		Importer: &schema.ResourceImporter{
 			State: schema.ImportStatePassthrough,
 		},
@@ -22,10 +34,122 @@ func resourceGlobalIPAddress() *schema.Resource {
 				Type:     schema.TypeString,
 				Required: true,
 			},
+			// InPreparation, InService
+			"contract_status": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"start_date": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"address_list": &schema.Schema{
+				Type: schema.TypeList,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"ipv4_address": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"ipv6_address": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"attached_service_code": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"contract_status": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+				Computed: true,
+			},
 		},
 	}
 }
 
+func getGlobalAddressV(api *p2pubapi.API, gis, iga string) (*protocol.GlobalAddressVGetResponse, error) {
+	args := protocol.GlobalAddressVGet{
+		GisServiceCode: gis,
+		IgaServiceCode: iga,
+	}
+	var res = protocol.GlobalAddressVGetResponse{}
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// globalAddressVReady reports whether the contract and all of its addresses
+// are InService and the number of addresses reached addressNum
+func globalAddressVReady(res *protocol.GlobalAddressVGetResponse, addressNum string) bool {
+	if res.ContractStatus != p2pubapi.InService.String() {
+		return false
+	}
+	if num, err := strconv.Atoi(addressNum); err == nil && len(res.IpAddressList) < num {
+		return false
+	}
+	for _, addr := range res.IpAddressList {
+		if addr.ContractStatus != p2pubapi.InService.String() {
+			return false
+		}
+	}
+	return true
+}
+
+func waitGlobalAddressV(api *p2pubapi.API, gis, iga, addressNum string, maxwait time.Duration) error {
+	start := time.Now()
+	for {
+		res, err := getGlobalAddressV(api, gis, iga)
+		if err != nil {
+			return err
+		}
+		if globalAddressVReady(res, addressNum) {
+			break
+		}
+		if time.Since(start) > maxwait {
+			return fmt.Errorf("timeout")
+		}
+		time.Sleep(pollInterval)
+	}
+
+	return nil
+}
+
+// isNotFoundError reports whether err of the API tells that the resource
+// does not exist (or no longer does), by the NotFound error types
+func isNotFoundError(err error) bool {
+	return strings.Contains(err.Error(), "NotFound")
+}
+
+// waitGlobalAddressVCancel waits until the contract leaves service. a
+// contract already removed after the cancel is done as well
+func waitGlobalAddressVCancel(api *p2pubapi.API, gis, iga string, maxwait time.Duration) error {
+	start := time.Now()
+	for {
+		res, err := getGlobalAddressV(api, gis, iga)
+		if err != nil && isNotFoundError(err) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if res.ContractStatus != p2pubapi.InService.String() &&
+			res.ContractStatus != p2pubapi.InPreparation.String() {
+			break
+		}
+		if time.Since(start) > maxwait {
+			return fmt.Errorf("timeout")
+		}
+		time.Sleep(pollInterval)
+	}
+
+	return nil
+}
+
 func resourceGlobalIPAddressCreate(d *schema.ResourceData, m interface{}) error {
 
 	api := m.(*Context).API
@@ -43,7 +167,11 @@ func resourceGlobalIPAddressCreate(d *schema.ResourceData, m interface{}) error
 
 	d.SetId(res.ServiceCode)
 
-	return nil
+	if err := waitGlobalAddressV(api, gis, res.ServiceCode, d.Get("address_num").(string), d.Timeout(schema.TimeoutCreate)); err != nil {
+		return err
+	}
+
+	return resourceGlobalIPAddressRead(d, m)
 }
 
 func resourceGlobalIPAddressRead(d *schema.ResourceData, m interface{}) error {
@@ -51,17 +179,27 @@ func resourceGlobalIPAddressRead(d *schema.ResourceData, m interface{}) error {
 	api := m.(*Context).API
 	gis := m.(*Context).GisServiceCode
 
-	args := protocol.GlobalAddressVGet{
-		GisServiceCode: gis,
-		IgaServiceCode: d.Id(),
-	}
-	var res = protocol.GlobalAddressVGetResponse{}
-
-	if err := p2pubapi.Call(*api, args, &res); err != nil {
+	res, err := getGlobalAddressV(api, gis, d.Id())
+	if err != nil {
 		return err
 	}
 
 	d.Set("address_num", res.AddressNum)
+	d.Set("contract_status", res.ContractStatus)
+	d.Set("start_date", res.StartDate)
+
+	address_list := make([]map[string]interface{}, 0)
+	for _, addr := range res.IpAddressList {
+		address_list = append(address_list, map[string]interface{}{
+			"ipv4_address":          addr.IPv4.IpAddress,
+			"ipv6_address":          addr.IPv6.IpAddress,
+			"attached_service_code": addr.AttachedServiceCode,
+			"contract_status":       addr.ContractStatus,
+		})
+	}
+	if err := d.Set("address_list", address_list); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -85,13 +223,17 @@ func resourceGlobalIPAddressUpdate(d *schema.ResourceData, m interface {}) error
 		if err := p2pubapi.Call(*api, args, &res); err != nil {
 			return err
 		}
+
+		if err := waitGlobalAddressV(api, gis, d.Id(), d.Get("address_num").(string), d.Timeout(schema.TimeoutUpdate)); err != nil {
+			return err
+		}
 		
 		d.SetPartial("address_num")
 	}
 
 	d.Partial(false)
 
-	return nil
+	return resourceGlobalIPAddressRead(d, m)
 }
 
 func resourceGlobalIPAddressDelete(d *schema.ResourceData, m interface {}) error {
@@ -99,6 +241,13 @@ func resourceGlobalIPAddressDelete(d *schema.ResourceData, m interface {}) error
 	api := m.(*Context).API
 	gis := m.(*Context).GisServiceCode
 
+	timeout := d.Timeout(schema.TimeoutDelete)
+
+	// pending changes have to be finished before cancellation
+	if err := waitGlobalAddressV(api, gis, d.Id(), "", timeout); err != nil {
+		return err
+	}
+
 	args := protocol.GlobalAddressVCancel{
 		GisServiceCode: gis,
 		IgaServiceCode: d.Id(),
@@ -109,5 +258,11 @@ func resourceGlobalIPAddressDelete(d *schema.ResourceData, m interface {}) error
 		return err
 	}
 
+	if err := waitGlobalAddressVCancel(api, gis, d.Id(), timeout); err != nil {
+		return err
+	}
+
+	d.SetId("")
+
 	return nil
 }
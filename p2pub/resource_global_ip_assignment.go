@@ -0,0 +1,254 @@
+package p2pub
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/iij/p2pubapi"
+	"github.com/iij/p2pubapi/protocol"
+)
+
+func resourceGlobalIPAssignment() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceGlobalIPAssignmentCreate,
+		Read:   resourceGlobalIPAssignmentRead,
+		Delete: resourceGlobalIPAssignmentDelete,
+
+		Timeouts: &schema.ResourceTimeout{
+			Create:  schema.DefaultTimeout(10 * time.Minute),
+			Delete:  schema.DefaultTimeout(10 * time.Minute),
+			Default: schema.DefaultTimeout(10 * time.Minute),
+		},
+
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"global_ip_address": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"ipv4_address": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"virtual_server": &schema.Schema{
+				Type:          schema.TypeString,
+				Optional:      true,
+				Computed:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"load_balancer"},
+			},
+			"load_balancer": &schema.Schema{
+				Type:          schema.TypeString,
+				Optional:      true,
+				Computed:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"virtual_server"},
+			},
+		},
+	}
+}
+
+//
+// api call
+//
+
+func attachGlobalAddressV(api *p2pubapi.API, gis, iga, address, target string) error {
+	args := protocol.GlobalAddressVAttach{
+		GisServiceCode: gis,
+		IgaServiceCode: iga,
+		IpAddress:      address,
+		ServiceCode:    target,
+	}
+	var res = protocol.GlobalAddressVAttachResponse{}
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return err
+	}
+	return nil
+}
+
+func detachGlobalAddressV(api *p2pubapi.API, gis, iga, address string) error {
+	args := protocol.GlobalAddressVDetach{
+		GisServiceCode: gis,
+		IgaServiceCode: iga,
+		IpAddress:      address,
+	}
+	var res = protocol.GlobalAddressVDetachResponse{}
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return err
+	}
+	return nil
+}
+
+// waitGlobalAddressVAttached waits until address of iga is in service and
+// attached to attached, or detached when attached is ""
+func waitGlobalAddressVAttached(api *p2pubapi.API, gis, iga, address, attached string, maxwait time.Duration) error {
+	start := time.Now()
+	for {
+		res, err := getGlobalAddressV(api, gis, iga)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, addr := range res.IpAddressList {
+			if addr.IPv4.IpAddress != address {
+				continue
+			}
+			found = true
+			if addr.ContractStatus == p2pubapi.InService.String() && addr.AttachedServiceCode == attached {
+				return nil
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s is not an address of %s", address, iga)
+		}
+		if time.Since(start) > maxwait {
+			return fmt.Errorf("timeout")
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// runGlobalIPAssignment runs fn, which attaches address of iga to target or
+// detaches it when attached is "", and waits for the address and the target
+// to settle. a virtual server has to be stopped while its addresses change.
+// without target, only the address is waited for
+func runGlobalIPAssignment(c *Context, iga, address, target, attached string, timeout time.Duration, fn func() error) error {
+	api := c.API
+	gis := c.GisServiceCode
+
+	run := func() error {
+		if err := fn(); err != nil {
+			return err
+		}
+		return waitGlobalAddressVAttached(api, gis, iga, address, attached, timeout)
+	}
+
+	if target == "" {
+		return run()
+	}
+
+	if strings.HasPrefix(target, "ifl") {
+		if err := waitLoadBalancer(api, gis, target, p2pubapi.InService, p2pubapi.Configured, timeout); err != nil {
+			return err
+		}
+		if err := run(); err != nil {
+			return err
+		}
+		return waitLoadBalancer(api, gis, target, p2pubapi.InService, p2pubapi.Configured, timeout)
+	}
+
+	return withVMStopped(c, target, timeout, func() error {
+		if err := run(); err != nil {
+			return err
+		}
+		return p2pubapi.WaitVM(api, gis, target, p2pubapi.InService, p2pubapi.Stopped, timeout)
+	})
+}
+
+//
+// resource operations
+//
+
+func resourceGlobalIPAssignmentCreate(d *schema.ResourceData, m interface{}) error {
+
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+	timeout := d.Timeout(schema.TimeoutCreate)
+
+	iga := d.Get("global_ip_address").(string)
+	address := d.Get("ipv4_address").(string)
+
+	target := d.Get("virtual_server").(string)
+	if target == "" {
+		target = d.Get("load_balancer").(string)
+	}
+	if target == "" {
+		return errors.New("virtual_server or load_balancer is required")
+	}
+
+	log.Printf("[DEBUG] p2pub: assign %s of %s to %s", address, iga, target)
+
+	if err := runGlobalIPAssignment(m.(*Context), iga, address, target, target, timeout, func() error {
+		return attachGlobalAddressV(api, gis, iga, address, target)
+	}); err != nil {
+		return err
+	}
+
+	d.SetId(iga + "/" + address)
+
+	return resourceGlobalIPAssignmentRead(d, m)
+}
+
+func resourceGlobalIPAssignmentRead(d *schema.ResourceData, m interface{}) error {
+
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+
+	iga, address, err := splitId(d.Id())
+	if err != nil {
+		return err
+	}
+
+	res, err := getGlobalAddressV(api, gis, iga)
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range res.IpAddressList {
+		if addr.IPv4.IpAddress != address || addr.AttachedServiceCode == "" {
+			continue
+		}
+		d.Set("global_ip_address", iga)
+		d.Set("ipv4_address", address)
+		if strings.HasPrefix(addr.AttachedServiceCode, "ifl") {
+			d.Set("load_balancer", addr.AttachedServiceCode)
+			d.Set("virtual_server", "")
+		} else {
+			d.Set("virtual_server", addr.AttachedServiceCode)
+			d.Set("load_balancer", "")
+		}
+		return nil
+	}
+
+	// the address is no longer assigned
+	d.SetId("")
+
+	return nil
+}
+
+func resourceGlobalIPAssignmentDelete(d *schema.ResourceData, m interface{}) error {
+
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+	timeout := d.Timeout(schema.TimeoutDelete)
+
+	iga, address, err := splitId(d.Id())
+	if err != nil {
+		return err
+	}
+
+	target := d.Get("virtual_server").(string)
+	if target == "" {
+		target = d.Get("load_balancer").(string)
+	}
+
+	// with no target in the state, no VM is stopped for the detach
+	if err := runGlobalIPAssignment(m.(*Context), iga, address, target, "", timeout, func() error {
+		return detachGlobalAddressV(api, gis, iga, address)
+	}); err != nil {
+		return err
+	}
+
+	d.SetId("")
+
+	return nil
+}
@@ -0,0 +1,261 @@
+package p2pub
+
+import (
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/iij/p2pubapi"
+	"github.com/iij/p2pubapi/protocol"
+)
+
+func resourceLBTrafficIP() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceLBTrafficIPCreate,
+		Read:   resourceLBTrafficIPRead,
+		Update: resourceLBTrafficIPUpdate,
+		Delete: resourceLBTrafficIPDelete,
+
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create:  schema.DefaultTimeout(10 * time.Minute),
+			Update:  schema.DefaultTimeout(10 * time.Minute),
+			Delete:  schema.DefaultTimeout(10 * time.Minute),
+			Default: schema.DefaultTimeout(10 * time.Minute),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"load_balancer": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"ipv4_name": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"ipv4_address": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+			},
+			"ipv4_domainname": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+			"ipv6_name": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"ipv6_address": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"ipv6_domainname": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+		},
+	}
+}
+
+//
+// api call
+//
+
+func deleteTrafficIp(api *p2pubapi.API, gisServiceCode, iflServiceCode, name string) error {
+	args := protocol.TrafficIpDelete{
+		GisServiceCode: gisServiceCode,
+		IflServiceCode: iflServiceCode,
+		TrafficIpName:  name,
+	}
+	res := protocol.TrafficIpDeleteResponse{}
+
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ipVersion: v4, v6
+func setTrafficIpDomainName(api *p2pubapi.API, gisServiceCode, iflServiceCode, name, ipVersion, domainName string) error {
+	args := protocol.TrafficIpDomainNameSet{
+		GisServiceCode: gisServiceCode,
+		IflServiceCode: iflServiceCode,
+		TrafficIpName:  name,
+		IpVersion:      ipVersion,
+		DomainName:     domainName,
+	}
+	res := protocol.TrafficIpDomainNameSetResponse{}
+
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//
+// resource operations
+//
+
+func resourceLBTrafficIPCreate(d *schema.ResourceData, m interface{}) error {
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+	timeout := d.Timeout(schema.TimeoutCreate)
+
+	ifl := d.Get("load_balancer").(string)
+	name := d.Get("ipv4_name").(string)
+
+	// other operations on the same load balancer have to finish first
+	lock := m.(*Context).loadBalancerLock(ifl)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := waitLoadBalancer(api, gis, ifl, p2pubapi.InService, p2pubapi.Configured, timeout); err != nil {
+		return err
+	}
+
+	if err := addTrafficIp(api, gis, ifl, name, d.Get("ipv4_address").(string)); err != nil {
+		return err
+	}
+
+	if err := waitLoadBalancer(api, gis, ifl, p2pubapi.InService, p2pubapi.Configured, timeout); err != nil {
+		return err
+	}
+
+	d.SetId(ifl + "/" + name)
+
+	if d.Get("ipv4_domainname").(string) != "" {
+		if err := setTrafficIpDomainName(api, gis, ifl, name, "v4", d.Get("ipv4_domainname").(string)); err != nil {
+			return err
+		}
+		if err := waitLoadBalancer(api, gis, ifl, p2pubapi.InService, p2pubapi.Configured, timeout); err != nil {
+			return err
+		}
+	}
+
+	if d.Get("ipv6_domainname").(string) != "" {
+		if err := setTrafficIpDomainName(api, gis, ifl, name, "v6", d.Get("ipv6_domainname").(string)); err != nil {
+			return err
+		}
+		if err := waitLoadBalancer(api, gis, ifl, p2pubapi.InService, p2pubapi.Configured, timeout); err != nil {
+			return err
+		}
+	}
+
+	return resourceLBTrafficIPRead(d, m)
+}
+
+func resourceLBTrafficIPRead(d *schema.ResourceData, m interface{}) error {
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+
+	ifl, name, err := splitId(d.Id())
+	if err != nil {
+		return err
+	}
+
+	res, err := getLoadBalancerInfo(api, gis, ifl)
+	if err != nil {
+		return err
+	}
+
+	for _, trafficip := range res.Lb.TrafficIpList {
+		if trafficip.IPv4.TrafficIpName != name {
+			continue
+		}
+		d.Set("load_balancer", ifl)
+		d.Set("ipv4_name", trafficip.IPv4.TrafficIpName)
+		d.Set("ipv4_address", trafficip.IPv4.TrafficIpAddress)
+		d.Set("ipv4_domainname", trafficip.IPv4.DomainName)
+		d.Set("ipv6_name", trafficip.IPv6.TrafficIpName)
+		d.Set("ipv6_address", trafficip.IPv6.TrafficIpAddress)
+		d.Set("ipv6_domainname", trafficip.IPv6.DomainName)
+		return nil
+	}
+
+	// removed outside of terraform
+	d.SetId("")
+
+	return nil
+}
+
+func resourceLBTrafficIPUpdate(d *schema.ResourceData, m interface{}) error {
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+	timeout := d.Timeout(schema.TimeoutUpdate)
+
+	ifl, name, err := splitId(d.Id())
+	if err != nil {
+		return err
+	}
+
+	lock := m.(*Context).loadBalancerLock(ifl)
+	lock.Lock()
+	defer lock.Unlock()
+
+	d.Partial(true)
+
+	if d.HasChange("ipv4_domainname") {
+		if err := setTrafficIpDomainName(api, gis, ifl, name, "v4", d.Get("ipv4_domainname").(string)); err != nil {
+			return err
+		}
+		if err := waitLoadBalancer(api, gis, ifl, p2pubapi.InService, p2pubapi.Configured, timeout); err != nil {
+			return err
+		}
+		d.SetPartial("ipv4_domainname")
+	}
+
+	if d.HasChange("ipv6_domainname") {
+		if err := setTrafficIpDomainName(api, gis, ifl, name, "v6", d.Get("ipv6_domainname").(string)); err != nil {
+			return err
+		}
+		if err := waitLoadBalancer(api, gis, ifl, p2pubapi.InService, p2pubapi.Configured, timeout); err != nil {
+			return err
+		}
+		d.SetPartial("ipv6_domainname")
+	}
+
+	d.Partial(false)
+
+	return resourceLBTrafficIPRead(d, m)
+}
+
+func resourceLBTrafficIPDelete(d *schema.ResourceData, m interface{}) error {
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+	timeout := d.Timeout(schema.TimeoutDelete)
+
+	ifl, name, err := splitId(d.Id())
+	if err != nil {
+		return err
+	}
+
+	lock := m.(*Context).loadBalancerLock(ifl)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := waitLoadBalancer(api, gis, ifl, p2pubapi.InService, p2pubapi.Configured, timeout); err != nil {
+		return err
+	}
+
+	if err := deleteTrafficIp(api, gis, ifl, name); err != nil {
+		return err
+	}
+
+	if err := waitLoadBalancer(api, gis, ifl, p2pubapi.InService, p2pubapi.Configured, timeout); err != nil {
+		return err
+	}
+
+	d.SetId("")
+
+	return nil
+}
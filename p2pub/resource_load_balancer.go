@@ -1,7 +1,11 @@
 package p2pub
 
 import (
+	"crypto/rand"
 	"fmt"
+	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/iij/p2pubapi"
@@ -22,7 +26,7 @@ func resourceLoadBalancer() *schema.Resource {
 		Delete: resourceLoadBalancerDelete,
 
 		Importer: &schema.ResourceImporter{
-			State: schema.ImportStatePassthrough,
+			State: resourceLoadBalancerImport,
 		},
 
 		Timeouts: &schema.ResourceTimeout{
@@ -32,6 +36,10 @@ func resourceLoadBalancer() *schema.Resource {
 			Default: schema.DefaultTimeout(10 * time.Minute),
 		},
 
+		CustomizeDiff: func(d *schema.ResourceDiff, m interface{}) error {
+			return validatePasswordHashOnlyDiff(d, "password", "password_hash_only")
+		},
+
 		Schema: map[string]*schema.Schema{
 			// D10M, D100M, D150M, D1000M
 			"type": &schema.Schema{
@@ -43,14 +51,46 @@ func resourceLoadBalancer() *schema.Resource {
 				Required: true,
 			},
 			"password": &schema.Schema{
-				Type:        schema.TypeString,
-				Required:    true,
-				DefaultFunc: schema.EnvDefaultFunc("VTM_PASSWORD", ""),
+				Type:             schema.TypeString,
+				Optional:         true,
+				Sensitive:        true,
+				DefaultFunc:      schema.EnvDefaultFunc("VTM_PASSWORD", ""),
+				DiffSuppressFunc: suppressHashedPasswordDiff,
+			},
+			// store only a hash of password in the state
+			"password_hash_only": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			// bump this to set the password again (or regenerate it)
+			"password_version": &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  0,
+			},
+			// generate a random password when password is not given
+			"generate_password": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"generated_password": &schema.Schema{
+				Type:      schema.TypeString,
+				Computed:  true,
+				Sensitive: true,
 			},
 			"label": &schema.Schema{
 				Type:     schema.TypeString,
 				Optional: true,
 			},
+			// version of the load balancer software. changing this upgrades
+			// each host in turn (slave first, then master)
+			"software_version": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
 			// Global, PrivateStandard, Private
 			"external_type": &schema.Schema{
 				Type:     schema.TypeString,
@@ -356,6 +396,23 @@ func waitLoadBalancer(api *p2pubapi.API, gis, ifl string, cstatus, rstatus p2pub
 	return nil
 }
 
+// loadBalancerLock returns the lock of the load balancer. a load balancer
+// accepts one change at a time, so the lock is held from waiting for the
+// previous change until the change is configured
+func (c *Context) loadBalancerLock(ifl string) *sync.Mutex {
+	c.lbLocksMutex.Lock()
+	defer c.lbLocksMutex.Unlock()
+	if c.lbLocks == nil {
+		c.lbLocks = make(map[string]*sync.Mutex)
+	}
+	lock, ok := c.lbLocks[ifl]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.lbLocks[ifl] = lock
+	}
+	return lock
+}
+
 func setLoadBalancerPassword(api *p2pubapi.API, gis, ifl, password string) error {
 	args := protocol.LBControlPanelAccountPasswordSet{
 		GisServiceCode: gis,
@@ -372,6 +429,63 @@ func setLoadBalancerPassword(api *p2pubapi.API, gis, ifl, password string) error
 	return nil
 }
 
+const (
+	loadBalancerPasswordLength = 16
+	loadBalancerPasswordChars  = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789"
+)
+
+func generateLoadBalancerPassword() (string, error) {
+	buf := make([]byte, loadBalancerPasswordLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = loadBalancerPasswordChars[int(b)%len(loadBalancerPasswordChars)]
+	}
+	return string(buf), nil
+}
+
+// applyLoadBalancerPassword sets the control panel password from password, or
+// from a generated one when generate_password is set. The generated password
+// is only regenerated on create or when rotate is true.
+func applyLoadBalancerPassword(api *p2pubapi.API, gis, ifl string, d *schema.ResourceData, rotate bool) error {
+	password := d.Get("password").(string)
+	if strings.HasPrefix(password, passwordHashPrefix) {
+		return fmt.Errorf("password must be given in plain text")
+	}
+
+	generated := false
+	if password == "" && d.Get("generate_password").(bool) {
+		password = d.Get("generated_password").(string)
+		if password == "" || rotate {
+			var err error
+			if password, err = generateLoadBalancerPassword(); err != nil {
+				return err
+			}
+		}
+		generated = true
+	}
+
+	if password == "" {
+		return nil
+	}
+
+	if err := setLoadBalancerPassword(api, gis, ifl, password); err != nil {
+		return err
+	}
+
+	if generated {
+		d.Set("generated_password", password)
+	} else {
+		d.Set("generated_password", "")
+		if d.Get("password_hash_only").(bool) {
+			d.Set("password", hashPassword(password))
+		}
+	}
+
+	return nil
+}
+
 func setLoadBalancerLabel(api *p2pubapi.API, gis, ifl, name string) error {
 	args := protocol.FwLbLabelSet{
 		GisServiceCode: gis,
@@ -387,6 +501,84 @@ func setLoadBalancerLabel(api *p2pubapi.API, gis, ifl, name string) error {
 	return nil
 }
 
+func getLoadBalancerInfo(api *p2pubapi.API, gis, ifl string) (*protocol.FwLbGetResponse, error) {
+	args := protocol.FwLbGet{
+		GisServiceCode: gis,
+		IflServiceCode: ifl,
+	}
+	res := protocol.FwLbGetResponse{}
+
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+func upgradeLoadBalancerHost(api *p2pubapi.API, gis, ifl, host, version string) error {
+	args := protocol.FwLbSoftwareVersionUpgrade{
+		GisServiceCode: gis,
+		IflServiceCode: ifl,
+		Host:           host,
+		Version:        version,
+	}
+	res := protocol.FwLbSoftwareVersionUpgradeResponse{}
+
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// upgradeLoadBalancer upgrades the load balancer software host by host.
+// For redundant pairs the slave is upgraded first, and the master is left
+// untouched if the slave fails to come back as Configured with the new version.
+func upgradeLoadBalancer(api *p2pubapi.API, gis, ifl, version string, timeout time.Duration) error {
+	info, err := getLoadBalancerInfo(api, gis, ifl)
+	if err != nil {
+		return err
+	}
+
+	hosts := []string{}
+	for _, host := range info.HostList {
+		if host.Master != "Yes" && host.LbSoftwareVersion != version {
+			hosts = append(hosts, "Slave")
+		}
+	}
+	for _, host := range info.HostList {
+		if host.Master == "Yes" && host.LbSoftwareVersion != version {
+			hosts = append(hosts, "Master")
+		}
+	}
+
+	for i, host := range hosts {
+		log.Printf("[DEBUG] p2pub: %s - upgrade %s host to %s", ifl, host, version)
+		if err := upgradeLoadBalancerHost(api, gis, ifl, host, version); err != nil {
+			if i == 0 {
+				return fmt.Errorf("upgrading %s host of %s failed, no host was changed: %s", host, ifl, err)
+			}
+			return err
+		}
+
+		if err := waitLoadBalancer(api, gis, ifl, p2pubapi.InService, p2pubapi.Configured, timeout); err != nil {
+			return err
+		}
+
+		info, err := getLoadBalancerInfo(api, gis, ifl)
+		if err != nil {
+			return err
+		}
+		for _, h := range info.HostList {
+			if (h.Master == "Yes") == (host == "Master") && h.LbSoftwareVersion != version {
+				return fmt.Errorf("%s host of %s is still running %s after upgrade, aborted", host, ifl, h.LbSoftwareVersion)
+			}
+		}
+	}
+
+	return nil
+}
+
 func getFilter(api *p2pubapi.API, gisServiceCode, iflServiceCode, direction string) *[]map[string]string {
 	args := protocol.FwLbFilterGet{
 		GisServiceCode: gisServiceCode,
@@ -610,8 +802,19 @@ func resourceLoadBalancerRead(d *schema.ResourceData, m interface{}) error {
 	}
 	d.Set("administration_server_allow_network_list", adminacl)
 
+	// traffic ips managed by p2pub_lb_traffic_ip are not listed here
+	managed := map[string]bool{}
+	for _, t := range d.Get("trafficip_list").([]interface{}) {
+		if t != nil {
+			managed[t.(map[string]interface{})["ipv4_name"].(string)] = true
+		}
+	}
+
 	trafficIPList := make([]map[string]string, 0)
 	for _, trafficip := range res.Lb.TrafficIpList {
+		if len(managed) != 0 && !managed[trafficip.IPv4.TrafficIpName] {
+			continue
+		}
 		trafficIPList = append(trafficIPList, map[string]string{
 			"ipv4_name":       trafficip.IPv4.TrafficIpName,
 			"ipv4_address":    trafficip.IPv4.TrafficIpAddress,
@@ -634,6 +837,7 @@ func resourceLoadBalancerRead(d *schema.ResourceData, m interface{}) error {
 			"internal_ipv4_address": host.Internal.IPv4Address,
 		})
 		if host.Master == "Yes" {
+			d.Set("software_version", host.LbSoftwareVersion)
 			d.Set("external_masterhost_address", host.External.IPv4Address)
 			if len(host.Internal.IPv4Address) == 0 {
 				d.Set("internal_masterhost_address", host.External.IPv4Address)
@@ -735,14 +939,18 @@ func resourceLoadBalancerCreate(d *schema.ResourceData, m interface{}) error {
 		}
 	}
 
-	if d.Get("password") != nil && d.Get("password").(string) != "" {
-		if err := setLoadBalancerPassword(api, gis, servicecode, d.Get("password").(string)); err != nil {
-			return err
-		}
+	if err := applyLoadBalancerPassword(api, gis, servicecode, d, true); err != nil {
+		return err
 	}
 
 	d.SetId(servicecode)
 
+	if d.Get("software_version") != nil && d.Get("software_version").(string) != "" {
+		if err := upgradeLoadBalancer(api, gis, servicecode, d.Get("software_version").(string), timeout); err != nil {
+			return err
+		}
+	}
+
 	if d.Get("filter_out_list") != nil {
 		if err := updateFilter(d, m, "out"); err != nil {
 			return err
@@ -773,6 +981,10 @@ func resourceLoadBalancerUpdate(d *schema.ResourceData, m interface{}) error {
 	api := m.(*Context).API
 	gis := m.(*Context).GisServiceCode
 
+	lock := m.(*Context).loadBalancerLock(d.Id())
+	lock.Lock()
+	defer lock.Unlock()
+
 	d.Partial(true)
 
 	if d.HasChange("type") {
@@ -790,17 +1002,36 @@ func resourceLoadBalancerUpdate(d *schema.ResourceData, m interface{}) error {
 		d.SetPartial("label")
 	}
 
-	if d.HasChange("password") {
-		if err := setLoadBalancerPassword(api, gis, d.Id(), d.Get("password").(string)); err != nil {
+	if d.HasChange("password") || d.HasChange("password_version") || d.HasChange("generate_password") {
+		if err := applyLoadBalancerPassword(api, gis, d.Id(), d, d.HasChange("password_version")); err != nil {
 			return err
 		}
 		d.SetPartial("password")
+		d.SetPartial("password_version")
+		d.SetPartial("generate_password")
+		d.SetPartial("generated_password")
+	}
+
+	if d.HasChange("password_hash_only") {
+		password := d.Get("password").(string)
+		if d.Get("password_hash_only").(bool) && password != "" && !strings.HasPrefix(password, passwordHashPrefix) {
+			d.Set("password", hashPassword(password))
+		}
+		d.SetPartial("password")
+		d.SetPartial("password_hash_only")
 	}
 
 	if d.HasChange("trafficip_list") {
 		return fmt.Errorf("updating trafficip is not supported")
 	}
 
+	if d.HasChange("software_version") {
+		if err := upgradeLoadBalancer(api, gis, d.Id(), d.Get("software_version").(string), d.Timeout(schema.TimeoutUpdate)); err != nil {
+			return err
+		}
+		d.SetPartial("software_version")
+	}
+
 	if d.HasChange("filter_out_list") {
 		if err := updateFilter(d, m, "out"); err != nil {
 			return err
@@ -827,6 +1058,25 @@ func resourceLoadBalancerUpdate(d *schema.ResourceData, m interface{}) error {
 	return nil
 }
 
+// resourceLoadBalancerImport accepts "<ifl>/<name>,<name>..." to import only
+// the named traffic ips into trafficip_list, leaving the others to
+// p2pub_lb_traffic_ip. all traffic ips are imported with a plain ifl
+func resourceLoadBalancerImport(d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	ifl, names, err := splitId(d.Id())
+	if err != nil {
+		return []*schema.ResourceData{d}, nil
+	}
+
+	trafficIPList := make([]map[string]interface{}, 0)
+	for _, name := range strings.Split(names, ",") {
+		trafficIPList = append(trafficIPList, map[string]interface{}{"ipv4_name": name})
+	}
+	d.SetId(ifl)
+	d.Set("trafficip_list", trafficIPList)
+
+	return []*schema.ResourceData{d}, nil
+}
+
 func resourceLoadBalancerDelete(d *schema.ResourceData, m interface{}) error {
 	api := m.(*Context).API
 	gis := m.(*Context).GisServiceCode
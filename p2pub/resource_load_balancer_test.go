@@ -0,0 +1,37 @@
+package p2pub
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordHash(t *testing.T) {
+	hash := hashPassword("secret")
+	if !strings.HasPrefix(hash, passwordHashPrefix) {
+		t.Fatalf("hash should start with %s: %s", passwordHashPrefix, hash)
+	}
+	if !suppressHashedPasswordDiff("password", hash, "secret", nil) {
+		t.Fatalf("diff should be suppressed for the same password")
+	}
+	if suppressHashedPasswordDiff("password", hash, "another", nil) {
+		t.Fatalf("diff should not be suppressed for a different password")
+	}
+	if suppressHashedPasswordDiff("password", "secret", "secret", nil) {
+		t.Fatalf("diff should not be suppressed for a plain text state")
+	}
+}
+
+func TestLoadBalancerPasswordGenerate(t *testing.T) {
+	password, err := generateLoadBalancerPassword()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(password) != loadBalancerPasswordLength {
+		t.Fatalf("unexpected length: %d", len(password))
+	}
+	for _, c := range password {
+		if !strings.ContainsRune(loadBalancerPasswordChars, c) {
+			t.Fatalf("unexpected character: %c", c)
+		}
+	}
+}
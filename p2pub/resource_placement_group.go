@@ -0,0 +1,162 @@
+package p2pub
+
+import (
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+)
+
+// p2pub_placement_group assigns server/storage groups to members of a named
+// set so that they are spread across the groups. it has no counterpart in
+// P2PUB and lives only in the state.
+func resourcePlacementGroup() *schema.Resource {
+	return &schema.Resource{
+		Create: resourcePlacementGroupCreate,
+		Read:   resourcePlacementGroupRead,
+		Update: resourcePlacementGroupUpdate,
+		Delete: resourcePlacementGroupDelete,
+
+		CustomizeDiff: resourcePlacementGroupCustomizeDiff,
+
+		Schema: map[string]*schema.Schema{
+			"name": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"members": &schema.Schema{
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				Required: true,
+			},
+			"groups": &schema.Schema{
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validation.StringInSlice([]string{"A", "B"}, false),
+				},
+				Optional: true,
+			},
+
+			//
+
+			// member -> group
+			"assignments": &schema.Schema{
+				Type: schema.TypeMap,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				Computed: true,
+			},
+		},
+	}
+}
+
+// assignPlacementGroups keeps the groups already assigned to members, and
+// assigns the least used group to new members in order
+func assignPlacementGroups(current map[string]string, members, groups []string) map[string]string {
+	count := make(map[string]int)
+	for _, g := range groups {
+		count[g] = 0
+	}
+
+	result := make(map[string]string)
+	for _, member := range members {
+		if g, ok := current[member]; ok {
+			if _, valid := count[g]; valid {
+				result[member] = g
+				count[g]++
+			}
+		}
+	}
+	for _, member := range members {
+		if _, ok := result[member]; ok {
+			continue
+		}
+		least := groups[0]
+		for _, g := range groups {
+			if count[g] < count[least] {
+				least = g
+			}
+		}
+		result[member] = least
+		count[least]++
+	}
+	return result
+}
+
+func placementGroupArgs(members, groups []interface{}) ([]string, []string) {
+	ms := make([]string, 0)
+	for _, m := range members {
+		ms = append(ms, m.(string))
+	}
+	gs := make([]string, 0)
+	for _, g := range groups {
+		gs = append(gs, g.(string))
+	}
+	if len(gs) == 0 {
+		gs = []string{"A", "B"}
+	}
+	return ms, gs
+}
+
+func resourcePlacementGroupCustomizeDiff(d *schema.ResourceDiff, m interface{}) error {
+	if !d.NewValueKnown("members") || !d.NewValueKnown("groups") {
+		return nil
+	}
+
+	current := make(map[string]string)
+	o, _ := d.GetChange("assignments")
+	for k, v := range o.(map[string]interface{}) {
+		current[k] = v.(string)
+	}
+
+	members, groups := placementGroupArgs(d.Get("members").([]interface{}), d.Get("groups").([]interface{}))
+	assignments := assignPlacementGroups(current, members, groups)
+
+	changed := len(assignments) != len(current)
+	for k, v := range assignments {
+		changed = changed || current[k] != v
+	}
+	if !changed {
+		return nil
+	}
+
+	return d.SetNew("assignments", assignments)
+}
+
+//
+// resource operations
+//
+
+func resourcePlacementGroupCreate(d *schema.ResourceData, m interface{}) error {
+	members, groups := placementGroupArgs(d.Get("members").([]interface{}), d.Get("groups").([]interface{}))
+	d.Set("assignments", assignPlacementGroups(map[string]string{}, members, groups))
+	d.SetId(d.Get("name").(string))
+
+	return nil
+}
+
+func resourcePlacementGroupRead(d *schema.ResourceData, m interface{}) error {
+	return nil
+}
+
+func resourcePlacementGroupUpdate(d *schema.ResourceData, m interface{}) error {
+	current := make(map[string]string)
+	o, _ := d.GetChange("assignments")
+	for k, v := range o.(map[string]interface{}) {
+		current[k] = v.(string)
+	}
+
+	members, groups := placementGroupArgs(d.Get("members").([]interface{}), d.Get("groups").([]interface{}))
+	d.Set("assignments", assignPlacementGroups(current, members, groups))
+
+	return nil
+}
+
+func resourcePlacementGroupDelete(d *schema.ResourceData, m interface{}) error {
+	d.SetId("")
+
+	return nil
+}
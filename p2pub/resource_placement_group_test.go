@@ -0,0 +1,29 @@
+package p2pub
+
+import (
+	"testing"
+)
+
+func TestAssignPlacementGroups(t *testing.T) {
+	groups := []string{"A", "B"}
+
+	assigned := assignPlacementGroups(map[string]string{}, []string{"db1", "db2", "db3"}, groups)
+	if assigned["db1"] != "A" || assigned["db2"] != "B" || assigned["db3"] != "A" {
+		t.Fatalf("groups should alternate: %v", assigned)
+	}
+
+	// existing members keep their groups
+	assigned = assignPlacementGroups(map[string]string{"db2": "B", "db3": "A"}, []string{"db2", "db3", "db4"}, groups)
+	if assigned["db2"] != "B" || assigned["db3"] != "A" {
+		t.Fatalf("existing members should keep groups: %v", assigned)
+	}
+	if len(assigned) != 3 {
+		t.Fatalf("unexpected assignments: %v", assigned)
+	}
+
+	// new member goes to the least used group
+	assigned = assignPlacementGroups(map[string]string{"db1": "A", "db3": "A"}, []string{"db1", "db3", "db5"}, groups)
+	if assigned["db5"] != "B" {
+		t.Fatalf("new member should be assigned to B: %v", assigned)
+	}
+}
@@ -0,0 +1,157 @@
+package p2pub
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/iij/p2pubapi"
+)
+
+func resourcePrivateNetworkAttachment() *schema.Resource {
+	return &schema.Resource{
+		Create: resourcePrivateNetworkAttachmentCreate,
+		Read:   resourcePrivateNetworkAttachmentRead,
+		Delete: resourcePrivateNetworkAttachmentDelete,
+
+		Timeouts: &schema.ResourceTimeout{
+			Create:  schema.DefaultTimeout(10 * time.Minute),
+			Delete:  schema.DefaultTimeout(10 * time.Minute),
+			Default: schema.DefaultTimeout(10 * time.Minute),
+		},
+
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"virtual_server": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"private_network": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"mac_address": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+// findPrivateNetworkMacAddress returns the MAC address of the NIC on ivl.
+// mac is preferred when the VM has two or more NICs on ivl.
+func findPrivateNetworkMacAddress(api *p2pubapi.API, gis, ivm, ivl, mac string) (string, error) {
+	info, err := getVMInfo(api, gis, ivm)
+	if err != nil {
+		return "", err
+	}
+	found := ""
+	for m, code := range privateNetworkMacAddresses(info) {
+		if code != ivl {
+			continue
+		}
+		if m == mac {
+			return m, nil
+		}
+		if found == "" || m < found {
+			found = m
+		}
+	}
+	if mac != "" {
+		// the NIC known to this resource is gone
+		return "", nil
+	}
+	return found, nil
+}
+
+//
+// resource operations
+//
+
+func resourcePrivateNetworkAttachmentCreate(d *schema.ResourceData, m interface{}) error {
+
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+	timeout := d.Timeout(schema.TimeoutCreate)
+
+	ivm := d.Get("virtual_server").(string)
+	ivl := d.Get("private_network").(string)
+
+	log.Printf("[DEBUG] p2pub: connect %s to %s", ivm, ivl)
+
+	var mac string
+	if err := withVMStopped(m.(*Context), ivm, timeout, func() error {
+		var err error
+		mac, err = attachNetworkInterface(api, gis, ivm, ivl, timeout)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	d.SetId(ivm + "/" + ivl)
+	d.Set("mac_address", mac)
+
+	return resourcePrivateNetworkAttachmentRead(d, m)
+}
+
+func resourcePrivateNetworkAttachmentRead(d *schema.ResourceData, m interface{}) error {
+
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+
+	ivm, ivl, err := splitId(d.Id())
+	if err != nil {
+		return err
+	}
+
+	mac, err := findPrivateNetworkMacAddress(api, gis, ivm, ivl, d.Get("mac_address").(string))
+	if err != nil {
+		return err
+	}
+	if mac == "" {
+		// disconnected outside of this resource
+		d.SetId("")
+		return nil
+	}
+
+	d.Set("virtual_server", ivm)
+	d.Set("private_network", ivl)
+	d.Set("mac_address", mac)
+
+	return nil
+}
+
+func resourcePrivateNetworkAttachmentDelete(d *schema.ResourceData, m interface{}) error {
+
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+	timeout := d.Timeout(schema.TimeoutDelete)
+
+	ivm, ivl, err := splitId(d.Id())
+	if err != nil {
+		return err
+	}
+
+	mac := d.Get("mac_address").(string)
+	if mac == "" {
+		return fmt.Errorf("MAC address of the NIC on %s is unknown", ivl)
+	}
+
+	log.Printf("[DEBUG] p2pub: disconnect %s (%s) from %s", ivm, mac, ivl)
+
+	if err := withVMStopped(m.(*Context), ivm, timeout, func() error {
+		return detachPrivateNetwork(api, gis, ivm, mac, timeout)
+	}); err != nil {
+		return err
+	}
+
+	d.SetId("")
+
+	return nil
+}
@@ -1,8 +1,9 @@
 package p2pub
 
 import (
+	"strconv"
 	"time"
-	
+
 	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/iij/p2pubapi"
 	"github.com/iij/p2pubapi/protocol"
@@ -28,10 +29,82 @@ func resourceStorageArchive() *schema.Resource {
 				Type:     schema.TypeString,
 				Required: true,
 			},
+
+			//
+
+			"used_size": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"free_size": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"image_count": &schema.Schema{
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"image_list": &schema.Schema{
+				Type: schema.TypeList,
+				Elem: &schema.Resource{
+					Schema: archivedImageSchema(),
+				},
+				Computed: true,
+			},
 		},
 	}
 }
 
+//
+// api call
+//
+
+func getStorageArchive(api *p2pubapi.API, gis, iar string) (*protocol.StorageArchiveGetResponse, error) {
+	args := protocol.StorageArchiveGet{
+		GisServiceCode: gis,
+		IarServiceCode: iar,
+	}
+	var res = protocol.StorageArchiveGetResponse{}
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// readStorageArchiveContents sets archive_size, usage and the images in iar
+func readStorageArchiveContents(d *schema.ResourceData, api *p2pubapi.API, gis, iar string) error {
+	archive, err := getStorageArchive(api, gis, iar)
+	if err != nil {
+		return err
+	}
+
+	images, err := getArchivedImages(api, gis, iar)
+	if err != nil {
+		return err
+	}
+
+	image_list := make([]map[string]interface{}, 0, len(images))
+	for _, image := range images {
+		image_list = append(image_list, archivedImageAttributes(image))
+	}
+
+	used, free := archiveUsage(images, archive.ArchiveSize)
+
+	d.Set("archive_size", archive.ArchiveSize)
+	d.Set("used_size", strconv.Itoa(used))
+	d.Set("free_size", strconv.Itoa(free))
+	d.Set("image_count", len(images))
+	if err := d.Set("image_list", image_list); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//
+// resource operations
+//
+
 func resourceStorageArchiveCreate(d *schema.ResourceData, m interface{}) error {
 
 	api := m.(*Context).API
@@ -48,9 +121,8 @@ func resourceStorageArchiveCreate(d *schema.ResourceData, m interface{}) error {
 	}
 
 	d.SetId(res.ServiceCode)
-	d.Set("archive_size", res.ArchiveSize)
 
-	return nil
+	return resourceStorageArchiveRead(d, m)
 }
 
 func resourceStorageArchiveRead(d *schema.ResourceData, m interface{}) error {
@@ -58,19 +130,7 @@ func resourceStorageArchiveRead(d *schema.ResourceData, m interface{}) error {
 	api := m.(*Context).API
 	gis := m.(*Context).GisServiceCode
 
-	args := protocol.StorageArchiveGet{
-		GisServiceCode: gis,
-		IarServiceCode: d.Id(),
-	}
-	var res = protocol.StorageArchiveGetResponse{}
-
-	if err := p2pubapi.Call(*api, args, &res); err != nil {
-		return err
-	}
-
-	d.Set("archive_size", res.ArchiveSize)
-
-	return nil
+	return readStorageArchiveContents(d, api, gis, d.Id())
 }
 
 func resourceStorageArchiveUpdate(d *schema.ResourceData, m interface {}) error {
@@ -96,7 +156,7 @@ func resourceStorageArchiveUpdate(d *schema.ResourceData, m interface {}) error
 
 	d.Partial(false)
 
-	return nil
+	return resourceStorageArchiveRead(d, m)
 }
 
 func resourceStorageArchiveDelete(d *schema.ResourceData, m interface {}) error {
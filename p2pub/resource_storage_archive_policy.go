@@ -0,0 +1,336 @@
+package p2pub
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/iij/p2pubapi"
+	"github.com/iij/p2pubapi/protocol"
+)
+
+// p2pub_storage_archive_policy prunes old images in a Storage Archive.
+// images exceeding the retention are reported in expired_images on refresh
+// and deleted on the next apply. images archived before the policy are kept
+// unless prune_existing is set.
+func resourceStorageArchivePolicy() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceStorageArchivePolicyCreate,
+		Read:   resourceStorageArchivePolicyRead,
+		Update: resourceStorageArchivePolicyUpdate,
+		Delete: resourceStorageArchivePolicyDelete,
+
+		Timeouts: &schema.ResourceTimeout{
+			Default: schema.DefaultTimeout(5 * time.Minute),
+		},
+
+		Importer: &schema.ResourceImporter{
+			State: resourceStorageArchivePolicyImport,
+		},
+
+		CustomizeDiff: resourceStorageArchivePolicyCustomizeDiff,
+
+		Schema: map[string]*schema.Schema{
+			"storage_archive": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+			},
+			"rule": &schema.Schema{
+				Type:     schema.TypeList,
+				Required: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"label_prefix": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"keep": &schema.Schema{
+							Type:     schema.TypeInt,
+							Required: true,
+							ValidateFunc: func(v interface{}, k string) ([]string, []error) {
+								if v.(int) < 1 {
+									return nil, []error{fmt.Errorf("%s must be 1 or more, got %d", k, v.(int))}
+								}
+								return nil, nil
+							},
+						},
+					},
+				},
+			},
+			// also prune the images archived before the policy
+			"prune_existing": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+				ForceNew: true,
+			},
+
+			//
+
+			// only images archived after this are deleted. empty for all
+			"prune_after": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"archive_size": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"used_size": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"free_size": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"image_count": &schema.Schema{
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			// ids of the images to be deleted on the next apply
+			"expired_images": &schema.Schema{
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				Computed: true,
+			},
+		},
+	}
+}
+
+type archiveRetentionRule struct {
+	LabelPrefix string
+	Keep        int
+}
+
+//
+// api call
+//
+
+func deleteArchivedImage(api *p2pubapi.API, gis, iar, id string) error {
+	args := protocol.CustomOSImageDelete{
+		GisServiceCode: gis,
+		IarServiceCode: iar,
+		ImageId:        id,
+	}
+	var res = protocol.CustomOSImageDeleteResponse{}
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return err
+	}
+	return nil
+}
+
+//
+// retention
+//
+
+// expiredImages returns the ids of the images exceeding the retention. each
+// image is governed by the first rule whose label_prefix matches its label,
+// and images matching no rule or archived until after are kept
+func expiredImages(images []archivedImage, rules []archiveRetentionRule, after string) []string {
+	grouped := make([][]archivedImage, len(rules))
+	for _, image := range images {
+		for i, rule := range rules {
+			if strings.HasPrefix(image.Label, rule.LabelPrefix) {
+				grouped[i] = append(grouped[i], image)
+				break
+			}
+		}
+	}
+
+	expired := make([]string, 0)
+	for i, rule := range rules {
+		group := grouped[i]
+		sort.SliceStable(group, func(a, b int) bool {
+			return group[a].ArchivedDateTime > group[b].ArchivedDateTime
+		})
+		for j := rule.Keep; j < len(group); j++ {
+			if group[j].ArchivedDateTime > after {
+				expired = append(expired, group[j].ImageId)
+			}
+		}
+	}
+	return expired
+}
+
+// archiveUsage returns the total size of images and the rest of archiveSize
+// in GB
+func archiveUsage(images []archivedImage, archiveSize string) (int, int) {
+	used := 0
+	for _, image := range images {
+		size, _ := strconv.Atoi(image.ImageSize)
+		used += size
+	}
+	total, _ := strconv.Atoi(archiveSize)
+	return used, total - used
+}
+
+// latestArchivedDateTime returns the archived time of the newest image, or ""
+// when there are no images
+func latestArchivedDateTime(images []archivedImage) string {
+	latest := ""
+	for _, image := range images {
+		if image.ArchivedDateTime > latest {
+			latest = image.ArchivedDateTime
+		}
+	}
+	return latest
+}
+
+func archiveRetentionRules(d *schema.ResourceData) []archiveRetentionRule {
+	rules := make([]archiveRetentionRule, 0)
+	for _, r := range d.Get("rule").([]interface{}) {
+		rule := r.(map[string]interface{})
+		rules = append(rules, archiveRetentionRule{
+			LabelPrefix: rule["label_prefix"].(string),
+			Keep:        rule["keep"].(int),
+		})
+	}
+	return rules
+}
+
+func pruneStorageArchive(api *p2pubapi.API, gis, iar string, rules []archiveRetentionRule, after string) error {
+	images, err := getArchivedImages(api, gis, iar)
+	if err != nil {
+		return err
+	}
+	for _, id := range expiredImages(images, rules, after) {
+		log.Printf("[DEBUG] p2pub: %s - delete image %s", iar, id)
+		if err := deleteArchivedImage(api, gis, iar, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//
+// resource operations
+//
+
+func resourceStorageArchivePolicyCustomizeDiff(d *schema.ResourceDiff, m interface{}) error {
+	if d.Id() == "" {
+		return nil
+	}
+	// rules are compared on apply, so that a rule change also prunes
+	if d.HasChange("rule") {
+		return d.SetNewComputed("expired_images")
+	}
+	if len(d.Get("expired_images").([]interface{})) != 0 {
+		return d.SetNew("expired_images", []string{})
+	}
+	return nil
+}
+
+func resourceStorageArchivePolicyCreate(d *schema.ResourceData, m interface{}) error {
+
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+
+	iar := d.Get("storage_archive").(string)
+	if iar == "" {
+		contract, err := getContract(api, gis)
+		if err != nil {
+			return err
+		}
+		if contract.StorageArchive.ServiceCode == "" {
+			return errors.New("cannot find storage archive contract")
+		}
+		iar = contract.StorageArchive.ServiceCode
+	}
+
+	after := ""
+	if !d.Get("prune_existing").(bool) {
+		images, err := getArchivedImages(api, gis, iar)
+		if err != nil {
+			return err
+		}
+		after = latestArchivedDateTime(images)
+	}
+
+	if err := pruneStorageArchive(api, gis, iar, archiveRetentionRules(d), after); err != nil {
+		return err
+	}
+
+	d.SetId(iar)
+	d.Set("prune_after", after)
+
+	return resourceStorageArchivePolicyRead(d, m)
+}
+
+func resourceStorageArchivePolicyRead(d *schema.ResourceData, m interface{}) error {
+
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+
+	archive, err := getStorageArchive(api, gis, d.Id())
+	if err != nil {
+		return err
+	}
+
+	images, err := getArchivedImages(api, gis, d.Id())
+	if err != nil {
+		return err
+	}
+
+	used, free := archiveUsage(images, archive.ArchiveSize)
+	for _, image := range images {
+		// the next image of the same size may not fit
+		if size, _ := strconv.Atoi(image.ImageSize); size > free {
+			log.Printf("[WARN] p2pub: %s - archive is almost full (%dGB used of %sGB)", d.Id(), used, archive.ArchiveSize)
+			break
+		}
+	}
+
+	d.Set("storage_archive", d.Id())
+	d.Set("archive_size", archive.ArchiveSize)
+	d.Set("used_size", strconv.Itoa(used))
+	d.Set("free_size", strconv.Itoa(free))
+	d.Set("image_count", len(images))
+	d.Set("expired_images", expiredImages(images, archiveRetentionRules(d), d.Get("prune_after").(string)))
+
+	return nil
+}
+
+func resourceStorageArchivePolicyUpdate(d *schema.ResourceData, m interface{}) error {
+
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+
+	if err := pruneStorageArchive(api, gis, d.Id(), archiveRetentionRules(d), d.Get("prune_after").(string)); err != nil {
+		return err
+	}
+
+	return resourceStorageArchivePolicyRead(d, m)
+}
+
+// resourceStorageArchivePolicyImport keeps the images archived before the
+// import, as Create does
+func resourceStorageArchivePolicyImport(d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+
+	images, err := getArchivedImages(api, gis, d.Id())
+	if err != nil {
+		return nil, err
+	}
+	d.Set("prune_existing", false)
+	d.Set("prune_after", latestArchivedDateTime(images))
+
+	return []*schema.ResourceData{d}, nil
+}
+
+func resourceStorageArchivePolicyDelete(d *schema.ResourceData, m interface{}) error {
+	// images are left as they are
+	d.SetId("")
+	return nil
+}
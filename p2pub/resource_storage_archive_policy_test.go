@@ -0,0 +1,46 @@
+package p2pub
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpiredImages(t *testing.T) {
+	images := []archivedImage{
+		{ImageId: "1", Label: "nightly-web-1", ArchivedDateTime: "2018-01-01 00:00:00"},
+		{ImageId: "2", Label: "nightly-web-2", ArchivedDateTime: "2018-01-02 00:00:00"},
+		{ImageId: "3", Label: "nightly-web-3", ArchivedDateTime: "2018-01-03 00:00:00"},
+		{ImageId: "4", Label: "nightly-db-1", ArchivedDateTime: "2018-01-01 00:00:00"},
+		{ImageId: "5", Label: "nightly-db-2", ArchivedDateTime: "2018-01-02 00:00:00"},
+		{ImageId: "6", Label: "golden", ArchivedDateTime: "2017-01-01 00:00:00"},
+	}
+	rules := []archiveRetentionRule{
+		{LabelPrefix: "nightly-web-", Keep: 2},
+		{LabelPrefix: "nightly-", Keep: 1},
+	}
+
+	expired := expiredImages(images, rules, "")
+	if !reflect.DeepEqual(expired, []string{"1", "4"}) {
+		t.Fatalf("unexpected expired images: %v", expired)
+	}
+
+	// images archived before the policy are kept
+	expired = expiredImages(images, rules, "2018-01-01 00:00:00")
+	if len(expired) != 0 {
+		t.Fatalf("unexpected expired images: %v", expired)
+	}
+	if latest := latestArchivedDateTime(images); latest != "2018-01-03 00:00:00" {
+		t.Fatalf("unexpected latest archived time: %s", latest)
+	}
+}
+
+func TestArchiveUsage(t *testing.T) {
+	images := []archivedImage{
+		{ImageId: "1", ImageSize: "30"},
+		{ImageId: "2", ImageSize: "40"},
+	}
+	used, free := archiveUsage(images, "100")
+	if used != 70 || free != 30 {
+		t.Fatalf("unexpected usage: used %d, free %d", used, free)
+	}
+}
@@ -0,0 +1,156 @@
+package p2pub
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/iij/p2pubapi"
+)
+
+func resourceStorageAttachment() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceStorageAttachmentCreate,
+		Read:   resourceStorageAttachmentRead,
+		Delete: resourceStorageAttachmentDelete,
+
+		Timeouts: &schema.ResourceTimeout{
+			Create:  schema.DefaultTimeout(10 * time.Minute),
+			Delete:  schema.DefaultTimeout(10 * time.Minute),
+			Default: schema.DefaultTimeout(10 * time.Minute),
+		},
+
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"virtual_server": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			// ib* service code of an additional storage
+			"storage": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"pci_slot": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+// findStoragePciSlot returns the PCI slot of a storage attached to ivm as a
+// data device, or also as the boot device when includeBoot is set
+func findStoragePciSlot(api *p2pubapi.API, gis, ivm, storage string, includeBoot bool) (string, error) {
+	info, err := getVMInfo(api, gis, ivm)
+	if err != nil {
+		return "", err
+	}
+	for _, elm := range info.StorageList {
+		if elm.ServiceCode == storage && (includeBoot || elm.Boot != "Yes") {
+			return elm.PciSlot, nil
+		}
+	}
+	return "", nil
+}
+
+// suppressStandardStorageEncryptionDiff returns a DiffSuppressFunc for
+// encryption of an existing storage, which is ignored unless isExtended
+// reports the type as extended. the API reports encryption of extended types
+// only, so Read leaves the value of other types as configured
+func suppressStandardStorageEncryptionDiff(isExtended func(string) bool) schema.SchemaDiffSuppressFunc {
+	return func(k, old, new string, d *schema.ResourceData) bool {
+		return d.Id() != "" && !isExtended(d.Get("type").(string))
+	}
+}
+
+//
+// resource operations
+//
+
+func resourceStorageAttachmentCreate(d *schema.ResourceData, m interface{}) error {
+
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+	timeout := d.Timeout(schema.TimeoutCreate)
+
+	ivm := d.Get("virtual_server").(string)
+	storage := d.Get("storage").(string)
+
+	log.Printf("[DEBUG] p2pub: attach %s to %s", storage, ivm)
+
+	if err := withVMStopped(m.(*Context), ivm, timeout, func() error {
+		return attachDataDevice(api, gis, ivm, storage, timeout)
+	}); err != nil {
+		return err
+	}
+
+	d.SetId(ivm + "/" + storage)
+
+	return resourceStorageAttachmentRead(d, m)
+}
+
+func resourceStorageAttachmentRead(d *schema.ResourceData, m interface{}) error {
+
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+
+	ivm, storage, err := splitId(d.Id())
+	if err != nil {
+		return err
+	}
+
+	pci, err := findStoragePciSlot(api, gis, ivm, storage, false)
+	if err != nil {
+		return err
+	}
+	if pci == "" {
+		// detached outside of this resource
+		d.SetId("")
+		return nil
+	}
+
+	d.Set("virtual_server", ivm)
+	d.Set("storage", storage)
+	d.Set("pci_slot", pci)
+
+	return nil
+}
+
+func resourceStorageAttachmentDelete(d *schema.ResourceData, m interface{}) error {
+
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+	timeout := d.Timeout(schema.TimeoutDelete)
+
+	ivm, storage, err := splitId(d.Id())
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] p2pub: detach %s from %s", storage, ivm)
+
+	if err := withVMStopped(m.(*Context), ivm, timeout, func() error {
+		// pci slot may change while the VM is stopped
+		pci, err := findStoragePciSlot(api, gis, ivm, storage, false)
+		if err != nil {
+			return err
+		}
+		if pci == "" {
+			return fmt.Errorf("%s is not attached to %s", storage, ivm)
+		}
+		return detachDataDevice(api, gis, ivm, pci, timeout)
+	}); err != nil {
+		return err
+	}
+
+	d.SetId("")
+
+	return nil
+}
@@ -2,10 +2,13 @@ package p2pub
 
 import (
 	"errors"
+	"fmt"
+	"log"
 	"strings"
 	"time"
 
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
 	"github.com/iij/p2pubapi"
 	"github.com/iij/p2pubapi/protocol"
 )
@@ -27,6 +30,8 @@ func resourceSystemStorage() *schema.Resource {
 			State: schema.ImportStatePassthrough,
 		},
 
+		CustomizeDiff: resourceSystemStorageCustomizeDiff,
+
 		Schema: map[string]*schema.Schema{
 			"type": &schema.Schema{
 				Type:     schema.TypeString,
@@ -52,30 +57,114 @@ func resourceSystemStorage() *schema.Resource {
 				Optional: true,
 				Computed: true,
 			},
+			// "Yes" encrypts an SX storage on creation
 			"encryption": &schema.Schema{
-				Type:     schema.TypeString,
-				Optional: true,
-				Computed: true,
+				Type:             schema.TypeString,
+				Optional:         true,
+				Computed:         true,
+				ForceNew:         true,
+				ValidateFunc:     validation.StringInSlice([]string{"Yes", "No"}, false),
+				DiffSuppressFunc: suppressStandardStorageEncryptionDiff(isExtendedSystemStorage),
 			},
 			"mode": &schema.Schema{
-				Type:     schema.TypeString,
-				Optional: true,
-				Computed: true,
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				ValidateFunc: validation.StringInSlice([]string{"ReadWrite", "ReadOnly"}, false),
 			},
 
 			//
 
 			"root_ssh_key": &schema.Schema{
-				Type:     schema.TypeString,
-				Optional: true,
+				Type:          schema.TypeString,
+				Optional:      true,
+				Deprecated:    "use root_ssh_keys",
+				ConflictsWith: []string{"root_ssh_keys"},
+			},
+			"root_ssh_keys": &schema.Schema{
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				Optional:      true,
+				ConflictsWith: []string{"root_ssh_key"},
 			},
 			"root_password": &schema.Schema{
-				Type:     schema.TypeString,
+				Type:             schema.TypeString,
+				Optional:         true,
+				Sensitive:        true,
+				DiffSuppressFunc: suppressHashedPasswordDiff,
+			},
+			// password of Administrator on Windows
+			"administrator_password": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				Sensitive:        true,
+				DiffSuppressFunc: suppressHashedPasswordDiff,
+				ValidateFunc:     validateWindowsPassword,
+				ConflictsWith:    []string{"root_password", "root_ssh_key", "root_ssh_keys"},
+			},
+			// store only a hash of root_password in the state
+			"root_password_hash_only": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			// store only a hash of administrator_password in the state
+			"administrator_password_hash_only": &schema.Schema{
+				Type:     schema.TypeBool,
 				Optional: true,
+				Default:  false,
 			},
+			// base64 encoded UserData
 			"userdata": &schema.Schema{
-				Type:     schema.TypeString,
+				Type:             schema.TypeString,
+				Optional:         true,
+				StateFunc:        hashUserData,
+				DiffSuppressFunc: suppressLegacyUserDataDiff,
+				ConflictsWith:    []string{"cloud_config", "userdata_part"},
+			},
+			// raw cloud-config. encoded by the provider
+			"cloud_config": &schema.Schema{
+				Type:          schema.TypeString,
+				Optional:      true,
+				StateFunc:     hashUserData,
+				ValidateFunc:  validateCloudConfig,
+				ConflictsWith: []string{"userdata"},
+			},
+			"userdata_part": &schema.Schema{
+				Type:     schema.TypeList,
 				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"content_type": &schema.Schema{
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validation.StringInSlice(userDataContentTypes, false),
+						},
+						"filename": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"content": &schema.Schema{
+							Type:      schema.TypeString,
+							Required:  true,
+							StateFunc: hashUserData,
+						},
+					},
+				},
+				ConflictsWith: []string{"userdata"},
+			},
+			"userdata_gzip": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			// time to wait for OS shutdown of the attached VM before power off
+			"shutdown_timeout": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateDuration,
 			},
 			"source_image": &schema.Schema{
 				Type: schema.TypeMap,
@@ -97,6 +186,26 @@ func resourceSystemStorage() *schema.Resource {
 				},
 				Optional: true,
 			},
+			// change this to reinstall the storage from source_image (or the
+			// OS image of type). values only stored as hashes are not set again
+			"reinstall_trigger": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"windows_edition": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"windows_license": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			// the attached VM which is restarted to apply root_ssh_keys,
+			// root_password or userdata. only set in the plan
+			"restart_virtual_server": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
 		},
 	}
 }
@@ -155,6 +264,31 @@ func setSSHKey(api *p2pubapi.API, gis, iba, key string, timeout time.Duration) e
 	return nil
 }
 
+func deleteSSHKey(api *p2pubapi.API, gis, iba, key string, timeout time.Duration) error {
+	info, err := getSystemStorageInfo(api, gis, iba)
+	if err != nil {
+		return err
+	}
+	attachStatus := p2pubapi.NotAttached
+	if info.ResourceStatus == p2pubapi.Attached.String() {
+		attachStatus = p2pubapi.Attached
+	}
+	args := protocol.PublicKeyDelete{
+		GisServiceCode:     gis,
+		StorageServiceCode: iba,
+		PublicKey:          key,
+	}
+	var res = protocol.PublicKeyDeleteResponse{}
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return err
+	}
+	if err := p2pubapi.WaitSystemStorage(api, gis, iba,
+		p2pubapi.InService, attachStatus, timeout); err != nil {
+		return err
+	}
+	return nil
+}
+
 func setPassword(api *p2pubapi.API, gis, iba, password string, timeout time.Duration) error {
 	info, err := getSystemStorageInfo(api, gis, iba)
 	if err != nil {
@@ -205,6 +339,31 @@ func setUserData(api *p2pubapi.API, gis, iba, userdata string, timeout time.Dura
 	return nil
 }
 
+func setSystemStorageMode(api *p2pubapi.API, gis, iba, mode string, timeout time.Duration) error {
+	info, err := getSystemStorageInfo(api, gis, iba)
+	if err != nil {
+		return err
+	}
+	attachStatus := p2pubapi.NotAttached
+	if info.ResourceStatus == p2pubapi.Attached.String() {
+		attachStatus = p2pubapi.Attached
+	}
+	args := protocol.SystemStorageModeSet{
+		GisServiceCode:     gis,
+		StorageServiceCode: iba,
+		Mode:               mode,
+	}
+	var res = protocol.SystemStorageModeSetResponse{}
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return err
+	}
+	if err := p2pubapi.WaitSystemStorage(api, gis, iba,
+		p2pubapi.InService, attachStatus, timeout); err != nil {
+		return err
+	}
+	return nil
+}
+
 func restore(api *p2pubapi.API, gis, iba, iar, id string, timeout time.Duration) error {
 	info, err := getSystemStorageInfo(api, gis, iba)
 	if err != nil {
@@ -232,6 +391,31 @@ func restore(api *p2pubapi.API, gis, iba, iar, id string, timeout time.Duration)
 	return nil
 }
 
+func reinstallSystemStorage(api *p2pubapi.API, gis, iba string, timeout time.Duration) error {
+	info, err := getSystemStorageInfo(api, gis, iba)
+	if err != nil {
+		return err
+	}
+	attachStatus := p2pubapi.NotAttached
+	if info.ResourceStatus == p2pubapi.Attached.String() {
+		attachStatus = p2pubapi.Attached
+	}
+	args := protocol.Restore{
+		GisServiceCode:     gis,
+		StorageServiceCode: iba,
+		Image:              "Preinstalled",
+	}
+	var res = protocol.RestoreResponse{}
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return err
+	}
+	if err := p2pubapi.WaitSystemStorage(api, gis, iba,
+		p2pubapi.InService, attachStatus, timeout); err != nil {
+		return err
+	}
+	return nil
+}
+
 func copyImage(api *p2pubapi.API, src_gis, src_iar, src_id, dst_gis, dst_iar string) (string, string, error) {
 	args := protocol.StorageImageCopy{
 		SrcGisServiceCode: src_gis,
@@ -248,6 +432,198 @@ func copyImage(api *p2pubapi.API, src_gis, src_iar, src_id, dst_gis, dst_iar str
 	return res.IarServiceCode, res.ImageId, nil
 }
 
+// rootSSHKeys returns the keys given by root_ssh_key or root_ssh_keys
+func rootSSHKeys(key, keys interface{}) []string {
+	result := make([]string, 0)
+	if key != nil && key.(string) != "" {
+		result = append(result, key.(string))
+	}
+	if keys != nil {
+		for _, k := range keys.([]interface{}) {
+			if k != nil && k.(string) != "" {
+				result = append(result, k.(string))
+			}
+		}
+	}
+	return result
+}
+
+// diffSSHKeys returns the keys to add and the keys to remove
+func diffSSHKeys(old, new []string) ([]string, []string) {
+	added := make([]string, 0)
+	removed := make([]string, 0)
+	for _, n := range new {
+		found := false
+		for _, o := range old {
+			found = found || o == n
+		}
+		if !found {
+			added = append(added, n)
+		}
+	}
+	for _, o := range old {
+		found := false
+		for _, n := range new {
+			found = found || o == n
+		}
+		if !found {
+			removed = append(removed, o)
+		}
+	}
+	return added, removed
+}
+
+func reconcileSSHKeys(api *p2pubapi.API, gis, iba string, old, new []string, timeout time.Duration) error {
+	added, removed := diffSSHKeys(old, new)
+	for _, key := range removed {
+		if err := deleteSSHKey(api, gis, iba, key, timeout); err != nil {
+			return err
+		}
+	}
+	for _, key := range added {
+		if err := setSSHKey(api, gis, iba, key, timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// passwordKey returns the attribute holding the password of the storage:
+// administrator_password on Windows, otherwise root_password. root_password
+// of a Windows storage configured before administrator_password was added
+// is still returned, and set as the Administrator password
+func passwordKey(d *schema.ResourceData) string {
+	if d.Get("administrator_password").(string) != "" || d.HasChange("administrator_password") {
+		return "administrator_password"
+	}
+	return "root_password"
+}
+
+// applyPassword sets root_password or administrator_password, and replaces
+// it with its hash in the state when root_password_hash_only or
+// administrator_password_hash_only is set
+func applyPassword(api *p2pubapi.API, gis, iba string, d *schema.ResourceData, timeout time.Duration) error {
+	key := passwordKey(d)
+	password := d.Get(key).(string)
+	if password == "" {
+		// a password cannot be removed from the storage
+		log.Printf("[WARN] p2pub: %s - %s is removed from the configuration, but left on the storage", iba, key)
+		return nil
+	}
+	if strings.HasPrefix(password, passwordHashPrefix) {
+		return fmt.Errorf("%s must be given in plain text", key)
+	}
+	if err := setPassword(api, gis, iba, password, timeout); err != nil {
+		return err
+	}
+	if d.Get(key + "_hash_only").(bool) {
+		d.Set(key, hashPassword(password))
+	}
+	return nil
+}
+
+// validateWindowsPassword checks the complexity requirements of Windows:
+// 8 characters or more, characters from 3 of uppercase, lowercase, digits
+// and symbols, and not containing the account name
+func validateWindowsPassword(v interface{}, k string) ([]string, []error) {
+	password := v.(string)
+	if strings.HasPrefix(password, passwordHashPrefix) {
+		return nil, []error{fmt.Errorf("%s must be given in plain text", k)}
+	}
+	if len(password) < 8 || len(password) > 127 {
+		return nil, []error{fmt.Errorf("%s must be 8 to 127 characters", k)}
+	}
+	if strings.Contains(strings.ToLower(password), "administrator") {
+		return nil, []error{fmt.Errorf("%s must not contain the account name", k)}
+	}
+	var upper, lower, digit, symbol int
+	for _, c := range password {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			upper = 1
+		case c >= 'a' && c <= 'z':
+			lower = 1
+		case c >= '0' && c <= '9':
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	if upper+lower+digit+symbol < 3 {
+		return nil, []error{fmt.Errorf("%s must contain 3 of uppercase letters, lowercase letters, digits and symbols", k)}
+	}
+	return nil, nil
+}
+
+// reinstallRequested reports whether the storage is reinstalled in place:
+// reinstall_trigger has changed, or source_image has changed from an image to
+// another. Read cannot tell the image of a storage, so setting source_image
+// (e.g. after import) or removing it replaces the storage instead
+func reinstallRequested(d attributeDiff) bool {
+	if d.HasChange("reinstall_trigger") {
+		return true
+	}
+	if !d.HasChange("source_image") {
+		return false
+	}
+	o, n := d.GetChange("source_image")
+	old, _ := o.(map[string]interface{})
+	new, _ := n.(map[string]interface{})
+	return len(old) != 0 && len(new) != 0
+}
+
+// restoreSourceImage restores source_image to the storage, or reinstalls the
+// OS image of the type when source_image is not given
+func restoreSourceImage(api *p2pubapi.API, gis, iba string, d *schema.ResourceData, timeout time.Duration) error {
+	if d.Get("source_image") == nil || len(d.Get("source_image").(map[string]interface{})) == 0 {
+		return reinstallSystemStorage(api, gis, iba, timeout)
+	}
+	src_gis := d.Get("source_image.gis_service_code").(string)
+	src_iar := d.Get("source_image.iar_service_code").(string)
+	image_id := d.Get("source_image.image_id").(string)
+	if src_gis != gis {
+		return errors.New("Inter-contract image restore is currently not supported.")
+	}
+	return restore(api, gis, iba, src_iar, image_id, timeout)
+}
+
+// applyCredentials sets ssh keys, password and userdata to a fresh storage.
+// after reinstall, a password or UserData only stored as hashes in the state
+// cannot be set again and is skipped
+func applyCredentials(api *p2pubapi.API, gis, iba string, d *schema.ResourceData, timeout time.Duration) error {
+	for _, key := range rootSSHKeys(d.Get("root_ssh_key"), d.Get("root_ssh_keys")) {
+		if err := setSSHKey(api, gis, iba, key, timeout); err != nil {
+			return err
+		}
+	}
+
+	key := passwordKey(d)
+	if password := d.Get(key).(string); strings.HasPrefix(password, passwordHashPrefix) {
+		log.Printf("[WARN] p2pub: %s - %s is not set again since only its hash is stored", iba, key)
+	} else if password != "" {
+		if err := applyPassword(api, gis, iba, d, timeout); err != nil {
+			return err
+		}
+	}
+
+	if stale := staleUserDataKeys(d); len(stale) != 0 {
+		log.Printf("[WARN] p2pub: %s - UserData is not set again since only the hashes of %s are stored", iba, strings.Join(stale, ", "))
+		return nil
+	}
+
+	userdata, err := systemStorageUserData(d)
+	if err != nil {
+		return err
+	}
+	if userdata != "" {
+		if err := setUserData(api, gis, iba, userdata, timeout); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func isExtendedSystemStorage(stype string) bool {
 	if strings.Index(stype, "SX") == 0 {
 		return true
@@ -260,6 +636,77 @@ func isExtendedSystemStorage(stype string) bool {
 // reosurce operations
 //
 
+func resourceSystemStorageCustomizeDiff(d *schema.ResourceDiff, m interface{}) error {
+	if err := validateStorageTypeDiff(d, getSystemStorageTypes()); err != nil {
+		return err
+	}
+
+	for _, key := range []string{"root_password", "administrator_password"} {
+		if err := validatePasswordHashOnlyDiff(d, key, key+"_hash_only"); err != nil {
+			return err
+		}
+	}
+
+	stype := d.Get("type").(string)
+	if osType := systemStorageOSType(stype); osType != "" {
+		// root_password and root_ssh_keys on Windows were accepted before
+		// administrator_password, so they are only warned for now.
+		// root_password is set as the Administrator password by passwordKey
+		if osType == "Windows" && d.Get("root_password").(string) != "" {
+			log.Printf("[WARN] p2pub: storage type %s is Windows. root_password is deprecated for Windows and used as administrator_password. move it to administrator_password", stype)
+		}
+		if osType == "Windows" && len(rootSSHKeys(d.Get("root_ssh_key"), d.Get("root_ssh_keys"))) != 0 {
+			log.Printf("[WARN] p2pub: storage type %s is Windows. root_ssh_keys is deprecated for Windows, which does not use it", stype)
+		}
+		if osType != "Windows" && d.Get("administrator_password").(string) != "" {
+			return fmt.Errorf("administrator_password is only for Windows, but storage type %s is %s", stype, osType)
+		}
+	}
+
+	if d.Id() == "" {
+		return nil
+	}
+
+	if d.HasChange("source_image") && !reinstallRequested(d) {
+		if err := d.ForceNew("source_image"); err != nil {
+			return err
+		}
+	}
+
+	if userDataChanged(d) {
+		if err := validateUserDataResend(d); err != nil {
+			return err
+		}
+	}
+
+	if m == nil {
+		return nil
+	}
+
+	passwordChanged := false
+	for _, key := range []string{"root_password", "administrator_password"} {
+		o, n := d.GetChange(key)
+		passwordChanged = passwordChanged ||
+			n.(string) != "" && o.(string) != n.(string) && !suppressHashedPasswordDiff(key, o.(string), n.(string), nil)
+	}
+	reinstall := reinstallRequested(d)
+	if !passwordChanged && !reinstall && !d.HasChange("root_ssh_key") && !d.HasChange("root_ssh_keys") && !userDataChanged(d) && !d.HasChange("mode") {
+		return nil
+	}
+
+	info, err := getSystemStorageInfo(m.(*Context).API, m.(*Context).GisServiceCode, d.Id())
+	if err != nil {
+		return err
+	}
+	if info.ResourceStatus != p2pubapi.Attached.String() {
+		return nil
+	}
+
+	ivm := info.AttachedVirtualServer.ServiceCode
+	log.Printf("[WARN] p2pub: %s - virtual server %s will be restarted to apply the change", d.Id(), ivm)
+	return d.SetNew("restart_virtual_server", ivm)
+}
+
 func resourceSystemStorageCreate(d *schema.ResourceData, m interface{}) error {
 
 	api := m.(*Context).API
@@ -288,19 +735,21 @@ func resourceSystemStorageCreate(d *schema.ResourceData, m interface{}) error {
 
 	iba := res.ServiceCode
 
+	// restore, credentials and mode follow the creation, and any of them
+	// may fail, so the id is set first
+	d.SetId(iba)
+	d.Partial(true)
+
 	if err := p2pubapi.WaitSystemStorage(api, gis, iba,
 		p2pubapi.InService, p2pubapi.NotAttached, timeout); err != nil {
 		return err
 	}
+	d.SetPartial("type")
+	d.SetPartial("storage_group")
+	d.SetPartial("encryption")
 
 	if d.Get("source_image") != nil && len(d.Get("source_image").(map[string]interface{})) != 0 {
-		src_gis := d.Get("source_image.gis_service_code").(string)
-		src_iar := d.Get("source_image.iar_service_code").(string)
-		image_id := d.Get("source_image.image_id").(string)
-		if src_gis != gis {
-			return errors.New("Inter-contract image restore is currently not supported.")
-		}
-		if err := restore(api, gis, iba, src_iar, image_id, timeout); err != nil {
+		if err := restoreSourceImage(api, gis, iba, d, timeout); err != nil {
 			return err
 		}
 	}
@@ -311,25 +760,17 @@ func resourceSystemStorageCreate(d *schema.ResourceData, m interface{}) error {
 		}
 	}
 
-	if d.Get("root_ssh_key") != nil && d.Get("root_ssh_key").(string) != "" {
-		if err := setSSHKey(api, gis, iba, d.Get("root_ssh_key").(string), timeout); err != nil {
-			return err
-		}
-	}
-
-	if d.Get("root_password") != nil && d.Get("root_password").(string) != "" {
-		if err := setPassword(api, gis, iba, d.Get("root_password").(string), timeout); err != nil {
-			return err
-		}
+	if err := applyCredentials(api, gis, iba, d, timeout); err != nil {
+		return err
 	}
 
-	if d.Get("userdata") != nil && d.Get("userdata").(string) != "" {
-		if err := setUserData(api, gis, iba, d.Get("userdata").(string), timeout); err != nil {
+	if d.Get("mode").(string) != "" {
+		if err := setSystemStorageMode(api, gis, iba, d.Get("mode").(string), timeout); err != nil {
 			return err
 		}
 	}
 
-	d.SetId(iba)
+	d.Partial(false)
 
 	return resourceSystemStorageRead(d, m)
 }
@@ -350,7 +791,11 @@ func resourceSystemStorageRead(d *schema.ResourceData, m interface{}) error {
 	d.Set("storage_size", res.StorageSize)
 	d.Set("label", res.Label)
 	d.Set("mode", res.Mode)
+	d.Set("restart_virtual_server", "")
+	d.Set("windows_edition", res.WindowsEdition)
+	d.Set("windows_license", res.WindowsLicense)
 
+	// S types have no encryption in the response
 	if isExtendedSystemStorage(res.Type) {
 		d.Set("encryption", res.Encryption)
 	}
@@ -360,15 +805,34 @@ func resourceSystemStorageRead(d *schema.ResourceData, m interface{}) error {
 
 func resourceSystemStorageUpdate(d *schema.ResourceData, m interface{}) error {
 
-	api := m.(*Context).API
-	gis := m.(*Context).GisServiceCode
+	ctx := m.(*Context)
+	api := ctx.API
+	gis := ctx.GisServiceCode
 	timeout := d.Timeout(schema.TimeoutUpdate)
 
-	if !d.HasChange("label") && !d.HasChange("root_ssh_key") && !d.HasChange("root_password") && !d.HasChange("userdata") {
-		return nil
+	d.Partial(true)
+
+	for _, key := range []string{"root_password", "administrator_password"} {
+		if !d.HasChange(key + "_hash_only") {
+			continue
+		}
+		password := d.Get(key).(string)
+		if d.Get(key+"_hash_only").(bool) && password != "" && !strings.HasPrefix(password, passwordHashPrefix) {
+			d.Set(key, hashPassword(password))
+		}
+		d.SetPartial(key)
+		d.SetPartial(key + "_hash_only")
 	}
 
-	d.Partial(true)
+	// a removed password is left on the storage
+	passwordChanged := (d.HasChange("root_password") || d.HasChange("administrator_password")) &&
+		d.Get(passwordKey(d)).(string) != ""
+	reinstall := reinstallRequested(d)
+
+	if !d.HasChange("mode") && !d.HasChange("label") && !d.HasChange("root_ssh_key") && !d.HasChange("root_ssh_keys") && !passwordChanged && !userDataChanged(d) && !reinstall {
+		d.Partial(false)
+		return nil
+	}
 
 	info, err := getSystemStorageInfo(api, gis, d.Id())
 	// TODO:
@@ -376,6 +840,11 @@ func resourceSystemStorageUpdate(d *schema.ResourceData, m interface{}) error {
 		return err
 	}
 	vm_stopped := false
+	defer func() {
+		if vm_stopped {
+			ctx.releaseVM(info.AttachedVirtualServer.ServiceCode, "", timeout)
+		}
+	}()
 
 	if d.HasChange("label") {
 		if err := setSystemStorageLabel(api, gis, d.Id(), d.Get("label").(string)); err != nil {
@@ -384,49 +853,101 @@ func resourceSystemStorageUpdate(d *schema.ResourceData, m interface{}) error {
 		d.SetPartial("label")
 	}
 
-	if d.HasChange("root_ssh_key") {
+	if d.HasChange("mode") {
+		// power off the attached VM for the mode change, unless stopped above
+		if info.ResourceStatus == p2pubapi.Attached.String() && !vm_stopped {
+			if err := ctx.holdVMStopped(info.AttachedVirtualServer.ServiceCode, shutdownTimeout(d, ctx), timeout); err != nil {
+				return err
+			}
+			vm_stopped = true
+		}
+		if err := setSystemStorageMode(api, gis, d.Id(), d.Get("mode").(string), timeout); err != nil {
+			return err
+		}
+		d.SetPartial("mode")
+	}
+
+	if reinstall {
+		log.Printf("[DEBUG] p2pub: %s - reinstall", d.Id())
 		if info.ResourceStatus == p2pubapi.Attached.String() && !vm_stopped {
-			if err := power(api, gis, info.AttachedVirtualServer.ServiceCode, "Off", timeout); err != nil {
+			if err := ctx.holdVMStopped(info.AttachedVirtualServer.ServiceCode, shutdownTimeout(d, ctx), timeout); err != nil {
 				return err
 			}
 			vm_stopped = true
 		}
-		if err := setSSHKey(api, gis, d.Id(), d.Get("root_ssh_key").(string), timeout); err != nil {
+		if err := restoreSourceImage(api, gis, d.Id(), d, timeout); err != nil {
+			return err
+		}
+		d.SetPartial("source_image")
+		d.SetPartial("reinstall_trigger")
+		if err := applyCredentials(api, gis, d.Id(), d, timeout); err != nil {
+			return err
+		}
+		for _, key := range []string{"root_ssh_key", "root_ssh_keys", "root_password", "administrator_password",
+			"userdata", "cloud_config", "userdata_part", "userdata_gzip"} {
+			d.SetPartial(key)
+		}
+	}
+
+	if !reinstall && (d.HasChange("root_ssh_key") || d.HasChange("root_ssh_keys")) {
+		if info.ResourceStatus == p2pubapi.Attached.String() && !vm_stopped {
+			if err := ctx.holdVMStopped(info.AttachedVirtualServer.ServiceCode, shutdownTimeout(d, ctx), timeout); err != nil {
+				return err
+			}
+			vm_stopped = true
+		}
+		old_key, new_key := d.GetChange("root_ssh_key")
+		old_keys, new_keys := d.GetChange("root_ssh_keys")
+		if err := reconcileSSHKeys(api, gis, d.Id(),
+			rootSSHKeys(old_key, old_keys), rootSSHKeys(new_key, new_keys), timeout); err != nil {
 			return err
 		}
 		d.SetPartial("root_ssh_key")
+		d.SetPartial("root_ssh_keys")
 	}
 
-	if d.HasChange("root_password") {
+	if !reinstall && passwordChanged {
 		if info.ResourceStatus == p2pubapi.Attached.String() && !vm_stopped {
-			if err := power(api, gis, info.AttachedVirtualServer.ServiceCode, "Off", timeout); err != nil {
+			if err := ctx.holdVMStopped(info.AttachedVirtualServer.ServiceCode, shutdownTimeout(d, ctx), timeout); err != nil {
 				return err
 			}
 			vm_stopped = true
 		}
-		if err := setPassword(api, gis, d.Id(), d.Get("root_password").(string), timeout); err != nil {
+		if err := applyPassword(api, gis, d.Id(), d, timeout); err != nil {
 			return err
 		}
 		d.SetPartial("root_password")
+		d.SetPartial("administrator_password")
 	}
 
-	if d.HasChange("userdata") {
+	if !reinstall && userDataChanged(d) {
+		if err := validateUserDataResend(d); err != nil {
+			return err
+		}
+		userdata, err := systemStorageUserData(d)
+		if err != nil {
+			return err
+		}
 		if info.ResourceStatus == p2pubapi.Attached.String() && !vm_stopped {
-			if err := power(api, gis, info.AttachedVirtualServer.ServiceCode, "Off", timeout); err != nil {
+			if err := ctx.holdVMStopped(info.AttachedVirtualServer.ServiceCode, shutdownTimeout(d, ctx), timeout); err != nil {
 				return err
 			}
 			vm_stopped = true
 		}
-		if err := setUserData(api, gis, d.Id(), d.Get("userdata").(string), timeout); err != nil {
+		if err := setUserData(api, gis, d.Id(), userdata, timeout); err != nil {
 			return err
 		}
 		d.SetPartial("userdata")
+		d.SetPartial("cloud_config")
+		d.SetPartial("userdata_part")
+		d.SetPartial("userdata_gzip")
 	}
 
 	d.Partial(false)
 
 	if vm_stopped {
-		if err := power(api, gis, info.AttachedVirtualServer.ServiceCode, "On", timeout); err != nil {
+		vm_stopped = false
+		if err := ctx.releaseVM(info.AttachedVirtualServer.ServiceCode, "", timeout); err != nil {
 			return err
 		}
 	}
@@ -30,3 +30,29 @@ func TestSystemStorage(t *testing.T) {
 		},
 	})
 }
+
+func TestDiffSSHKeys(t *testing.T) {
+	old := rootSSHKeys("ssh-rsa AAA", []interface{}{"ssh-rsa BBB"})
+	new := rootSSHKeys("", []interface{}{"ssh-rsa BBB", "ssh-rsa CCC"})
+
+	added, removed := diffSSHKeys(old, new)
+	if len(added) != 1 || added[0] != "ssh-rsa CCC" {
+		t.Fatalf("unexpected added keys: %v", added)
+	}
+	if len(removed) != 1 || removed[0] != "ssh-rsa AAA" {
+		t.Fatalf("unexpected removed keys: %v", removed)
+	}
+}
+
+func TestValidateWindowsPassword(t *testing.T) {
+	for _, password := range []string{"Passw0rd", "pass-w0rd", "P@SSWORD1"} {
+		if _, errs := validateWindowsPassword(password, "administrator_password"); len(errs) != 0 {
+			t.Errorf("%s should be accepted: %v", password, errs)
+		}
+	}
+	for _, password := range []string{"Pa0rd", "password", "PASSWORD1", "Administrator-1", "sha256:abc"} {
+		if _, errs := validateWindowsPassword(password, "administrator_password"); len(errs) == 0 {
+			t.Errorf("%s should be rejected", password)
+		}
+	}
+}
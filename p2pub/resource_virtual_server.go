@@ -1,11 +1,15 @@
 package p2pub
 
 import (
+	"errors"
+	"fmt"
 	"time"
 	"log"
 	"strings"
+	"sync"
 	
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
 	"github.com/iij/p2pubapi"
 	"github.com/iij/p2pubapi/protocol"
 )
@@ -24,13 +28,16 @@ func resourceVirtualServer() *schema.Resource {
 		},
 
 		Importer: &schema.ResourceImporter{
-			State: schema.ImportStatePassthrough,
+			State: resourceVirtualServerImport,
 		},
+
+		CustomizeDiff: resourceVirtualServerCustomizeDiff,
 		
 		Schema: map[string]*schema.Schema{
 			"type": &schema.Schema{
 				Type:     schema.TypeString,
 				Required: true,
+				ValidateFunc: validateServerType,
 			},
 			"os_type": &schema.Schema{
 				Type:     schema.TypeString,
@@ -181,12 +188,80 @@ func resourceVirtualServer() *schema.Resource {
 				},
 				MaxItems: PRIVATE_NETWORK_MAX_ATTACH_COUNT,
 				Optional: true,
+				ConflictsWith: []string{"network_interface"},
+			},
+			// MAC addresses of the NICs attached for private_network, by
+			// service code. other NICs on the same networks are left as is
+			"private_network_mac_addresses": &schema.Schema{
+				Type: schema.TypeMap,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				Computed: true,
+			},
+			// NICs on Private networks, attached in order. this provider does
+			// not set static IPv4 addresses on them; they are configured in
+			// the OS, e.g. by UserData of the system storage
+			"network_interface": &schema.Schema{
+				Type: schema.TypeList,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"private_network_id": &schema.Schema{
+							Type: schema.TypeString,
+							Required: true,
+						},
+						// set this to adopt an already attached NIC
+						"mac_address": &schema.Schema{
+							Type: schema.TypeString,
+							Optional: true,
+							Computed: true,
+						},
+						"label": &schema.Schema{
+							Type: schema.TypeString,
+							Optional: true,
+						},
+					},
+				},
+				MaxItems: PRIVATE_NETWORK_MAX_ATTACH_COUNT,
+				Optional: true,
+				ConflictsWith: []string{"private_network"},
 			},
 			"enable_global_ip": &schema.Schema{
 				Type: schema.TypeBool,
 				Optional: true,
 				Default: false,
 			},
+			// running, stopped
+			"power_state": &schema.Schema{
+				Type: schema.TypeString,
+				Optional: true,
+				Computed: true,
+				ValidateFunc: validation.StringInSlice([]string{"running", "stopped"}, false),
+			},
+			// always stop the VM by power off instead of OS shutdown
+			"force_stop": &schema.Schema{
+				Type: schema.TypeBool,
+				Optional: true,
+				Default: false,
+			},
+			// time to wait for OS shutdown before power off, e.g. "5m"
+			"shutdown_timeout": &schema.Schema{
+				Type: schema.TypeString,
+				Optional: true,
+				ValidateFunc: validateDuration,
+			},
+			// IPv6 on the Global NIC. requires enable_global_ip
+			"enable_ipv6": &schema.Schema{
+				Type: schema.TypeBool,
+				Optional: true,
+				Default: false,
+			},
+			// IPv6 on the PrivateStandard NIC
+			"enable_private_standard_ipv6": &schema.Schema{
+				Type: schema.TypeBool,
+				Optional: true,
+				Default: false,
+			},
 		},
 	}
 }
@@ -194,13 +269,126 @@ func resourceVirtualServer() *schema.Resource {
 func needUpdateAttributes(d *schema.ResourceData) bool {
 	return d.Get("enable_global_ip") != nil ||
 		d.Get("private_network") != nil ||
+		d.Get("network_interface") != nil ||
 		d.Get("system_storage") != nil ||
 		d.Get("data_storage") != nil
 }
 
 func bootable(d *schema.ResourceData) bool {
-	// TODO: 
-	return d.Get("system_storage") != nil && d.Get("system_storage") != ""
+	if d.Get("system_storage") != nil && d.Get("system_storage") != "" {
+		return true
+	}
+	// boot device attached outside of system_storage (e.g. imported VM)
+	if d.HasChange("system_storage") {
+		return false
+	}
+	for _, elm := range d.Get("storage_list").([]interface{}) {
+		if elm.(map[string]interface{})["boot"] == "Yes" {
+			return true
+		}
+	}
+	return false
+}
+
+// powerOnOff returns the power state requested after create/update: "On" or "Off"
+func powerOnOff(d *schema.ResourceData) string {
+	if !bootable(d) || d.Get("power_state") == "stopped" {
+		return "Off"
+	}
+	return "On"
+}
+
+// storageGroup returns the storage group of a system (iba/ica) or additional storage
+func storageGroup(api *p2pubapi.API, gis, storage string) (string, error) {
+	if strings.HasPrefix(storage, "iba") || strings.HasPrefix(storage, "ica") {
+		info, err := getSystemStorageInfo(api, gis, storage)
+		if err != nil {
+			return "", err
+		}
+		return info.StorageGroup, nil
+	}
+	info, err := getAdditionalStorageInfo(api, gis, storage)
+	if err != nil {
+		return "", err
+	}
+	return info.StorageGroup, nil
+}
+
+func resourceVirtualServerCustomizeDiff(d *schema.ResourceDiff, m interface{}) error {
+	if d.NewValueKnown("enable_ipv6") && d.NewValueKnown("enable_global_ip") &&
+		d.Get("enable_ipv6").(bool) && !d.Get("enable_global_ip").(bool) {
+		return errors.New("enable_ipv6 requires enable_global_ip")
+	}
+
+	if err := validateServerTypeDiff(d); err != nil {
+		return err
+	}
+
+	if err := validatePowerStateDiff(d); err != nil {
+		return err
+	}
+
+	return validateServerGroupDiff(d, m)
+}
+
+// validatePowerStateDiff verifies that the VM has a boot device when
+// power_state is running
+func validatePowerStateDiff(d *schema.ResourceDiff) error {
+	if !d.NewValueKnown("power_state") || d.Get("power_state") != "running" {
+		return nil
+	}
+	if !d.NewValueKnown("system_storage") || d.Get("system_storage") != "" {
+		return nil
+	}
+	// boot device attached outside of system_storage (e.g. imported VM)
+	if d.Id() != "" && !d.HasChange("system_storage") {
+		for _, elm := range d.Get("storage_list").([]interface{}) {
+			if elm.(map[string]interface{})["boot"] == "Yes" {
+				return nil
+			}
+		}
+	}
+	return errors.New("power_state running requires system_storage")
+}
+
+// validateServerGroupDiff verifies that the storages attached to the VM are
+// in the same group as server_group
+func validateServerGroupDiff(d *schema.ResourceDiff, m interface{}) error {
+	if m == nil || !d.NewValueKnown("server_group") {
+		return nil
+	}
+	group := d.Get("server_group").(string)
+	if group == "" {
+		return nil
+	}
+	if !d.HasChange("server_group") && !d.HasChange("system_storage") && !d.HasChange("data_storage") {
+		return nil
+	}
+
+	api := m.(*Context).API
+	gis := m.(*Context).GisServiceCode
+
+	storages := make([]string, 0)
+	if d.NewValueKnown("system_storage") && d.Get("system_storage").(string) != "" {
+		storages = append(storages, d.Get("system_storage").(string))
+	}
+	if d.NewValueKnown("data_storage") {
+		for _, ib := range d.Get("data_storage").(*schema.Set).List() {
+			storages = append(storages, ib.(string))
+		}
+	}
+
+	for _, storage := range storages {
+		sg, err := storageGroup(api, gis, storage)
+		if err != nil {
+			return err
+		}
+		if sg != "" && sg != group {
+			return fmt.Errorf("storage %s is in storage group %s, but server_group is %s", storage, sg, group)
+		}
+	}
+
+	return nil
 }
 
 //
@@ -248,6 +436,206 @@ func power(api *p2pubapi.API, gis, ivm, onoff string, timeout time.Duration) err
 	return nil
 }
 
+// shutdownVM shuts down the OS of the VM and waits up to shutdownTimeout for
+// it to stop, then powers it off. the VM is powered off at once when
+// shutdownTimeout is zero. errors of the shutdown request are returned as is.
+func shutdownVM(api *p2pubapi.API, gis, ivm string, shutdownTimeout, timeout time.Duration) error {
+	if info, err := getVMInfo(api, gis, ivm); err != nil {
+		return err
+	} else if info.ResourceStatus == p2pubapi.Stopped.String() {
+		return nil
+	}
+	if shutdownTimeout <= 0 {
+		log.Printf("[INFO] p2pub: %s - power off", ivm)
+		return power(api, gis, ivm, "Off", timeout)
+	}
+
+	log.Printf("[INFO] p2pub: %s - shut down (timeout %s)", ivm, shutdownTimeout)
+	args := protocol.VMPower{
+		GisServiceCode: gis,
+		IvmServiceCode: ivm,
+		Power: "Shutdown",
+	}
+	var res = protocol.VMPowerResponse{}
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return err
+	}
+	if err := p2pubapi.WaitVM(api, gis, ivm, p2pubapi.InService, p2pubapi.Stopped, shutdownTimeout); err != nil {
+		log.Printf("[WARN] p2pub: %s - not stopped in %s, power off: %s", ivm, shutdownTimeout, err)
+		return power(api, gis, ivm, "Off", timeout)
+	}
+	log.Printf("[INFO] p2pub: %s - shut down completed", ivm)
+	return nil
+}
+
+// shutdownTimeout returns shutdown_timeout of the resource, or the provider's
+// one when it is not set
+func shutdownTimeout(d *schema.ResourceData, c *Context) time.Duration {
+	if v, ok := d.GetOk("shutdown_timeout"); ok {
+		if t, err := time.ParseDuration(v.(string)); err == nil {
+			return t
+		}
+	}
+	return c.ShutdownTimeout
+}
+
+// vmShutdownTimeout is shutdownTimeout which honors force_stop of the VM
+func vmShutdownTimeout(d *schema.ResourceData, c *Context) time.Duration {
+	if d.Get("force_stop").(bool) {
+		return 0
+	}
+	return shutdownTimeout(d, c)
+}
+
+// vmPowerHold counts operations which need the VM to be stopped, so that
+// concurrent operations on the same VM share a single stop/start.
+type vmPowerHold struct {
+	sync.Mutex
+	count int
+	// the VM was running before the first hold
+	running bool
+	// power state requested by holders on release: "On", "Off" or ""
+	request string
+}
+
+// acquire counts a hold. running is the power state before the first hold
+func (h *vmPowerHold) acquire(running bool) {
+	if h.count == 0 {
+		h.running = running
+		h.request = ""
+	}
+	h.count++
+}
+
+// requestPower records onoff to apply on the last release. "Off" wins over
+// "On", and "" does not override a request
+func (h *vmPowerHold) requestPower(onoff string) {
+	if onoff == "Off" || h.request == "" {
+		h.request = onoff
+	}
+}
+
+// release counts down a hold with onoff as in requestPower, and reports
+// whether the VM is to be started, which is only by the last release
+func (h *vmPowerHold) release(onoff string) bool {
+	if h.count == 0 {
+		return false
+	}
+	h.requestPower(onoff)
+	h.count--
+	if h.count > 0 {
+		return false
+	}
+	return h.request == "On" || h.request == "" && h.running
+}
+
+func (c *Context) vmPowerHold(ivm string) *vmPowerHold {
+	c.powerHoldsMutex.Lock()
+	defer c.powerHoldsMutex.Unlock()
+	if c.powerHolds == nil {
+		c.powerHolds = make(map[string]*vmPowerHold)
+	}
+	hold, ok := c.powerHolds[ivm]
+	if !ok {
+		hold = &vmPowerHold{}
+		c.powerHolds[ivm] = hold
+	}
+	return hold
+}
+
+// holdVMStopped stops the VM unless another operation already holds it stopped.
+// every successful call must be paired with releaseVM.
+func (c *Context) holdVMStopped(ivm string, shutdown, timeout time.Duration) error {
+	hold := c.vmPowerHold(ivm)
+	hold.Lock()
+	defer hold.Unlock()
+	running := false
+	if hold.count == 0 {
+		info, err := getVMInfo(c.API, c.GisServiceCode, ivm)
+		if err != nil {
+			return err
+		}
+		running = info.ResourceStatus == p2pubapi.Running.String()
+		if err := shutdownVM(c.API, c.GisServiceCode, ivm, shutdown, timeout); err != nil {
+			return err
+		}
+	}
+	hold.acquire(running)
+	log.Printf("[DEBUG] p2pub: %s - power hold %d", ivm, hold.count)
+	return nil
+}
+
+// releaseVM releases a hold taken by holdVMStopped. onoff is "On" to start the
+// VM, "Off" to keep it stopped, or "" to restore the state before the first
+// hold. the VM is started by the last release, and "Off" wins over "On".
+func (c *Context) releaseVM(ivm, onoff string, timeout time.Duration) error {
+	hold := c.vmPowerHold(ivm)
+	hold.Lock()
+	defer hold.Unlock()
+	if hold.count == 0 {
+		return nil
+	}
+	start := hold.release(onoff)
+	log.Printf("[DEBUG] p2pub: %s - power release %d", ivm, hold.count)
+	if start {
+		return power(c.API, c.GisServiceCode, ivm, "On", timeout)
+	}
+	return nil
+}
+
+// setVMShutdownTimeout records the shutdown timeout of the VM resource,
+// honoring its force_stop, for operations of other resources on the VM
+func (c *Context) setVMShutdownTimeout(ivm string, shutdown time.Duration) {
+	c.powerHoldsMutex.Lock()
+	defer c.powerHoldsMutex.Unlock()
+	if c.vmShutdownTimeouts == nil {
+		c.vmShutdownTimeouts = make(map[string]time.Duration)
+	}
+	c.vmShutdownTimeouts[ivm] = shutdown
+}
+
+// vmShutdownTimeoutOf returns the shutdown timeout recorded for the VM, or
+// the provider's one when the VM resource has not been read in this run
+func (c *Context) vmShutdownTimeoutOf(ivm string) time.Duration {
+	c.powerHoldsMutex.Lock()
+	defer c.powerHoldsMutex.Unlock()
+	if shutdown, ok := c.vmShutdownTimeouts[ivm]; ok {
+		return shutdown
+	}
+	return c.ShutdownTimeout
+}
+
+// withVMStopped runs fn while the VM is held stopped, and the VM is started
+// again when it was running before. the VM is shut down as its force_stop
+// and shutdown_timeout tell
+func withVMStopped(c *Context, ivm string, timeout time.Duration, fn func() error) error {
+	if err := c.holdVMStopped(ivm, c.vmShutdownTimeoutOf(ivm), timeout); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		c.releaseVM(ivm, "", timeout)
+		return err
+	}
+	return c.releaseVM(ivm, "", timeout)
+}
+
+// setVMPower starts ("On") or stops ("Off") the VM. while other operations
+// hold the VM stopped, the request is left to the last releaseVM instead.
+func (c *Context) setVMPower(ivm, onoff string, shutdown, timeout time.Duration) error {
+	hold := c.vmPowerHold(ivm)
+	hold.Lock()
+	defer hold.Unlock()
+	if hold.count > 0 {
+		hold.requestPower(onoff)
+		log.Printf("[DEBUG] p2pub: %s - power %s on release", ivm, onoff)
+		return nil
+	}
+	if onoff == "Off" {
+		return shutdownVM(c.API, c.GisServiceCode, ivm, shutdown, timeout)
+	}
+	return power(c.API, c.GisServiceCode, ivm, "On", timeout)
+}
+
 func allocateGlobalIP(api *p2pubapi.API, gis, ivm string, timeout time.Duration) (string, error) {
 	args := protocol.GlobalAddressAllocate{
 		GisServiceCode: gis,
@@ -394,6 +782,226 @@ func detachPrivateNetwork(api *p2pubapi.API, gis, ivm, mac string, timeout time.
 	return nil
 }
 
+// networkType: Global, PrivateStandard
+func setIPv6(api *p2pubapi.API, gis, ivm, networkType string, enable bool, timeout time.Duration) error {
+	if enable {
+		args := protocol.IPv6Enable{
+			GisServiceCode: gis,
+			IvmServiceCode: ivm,
+			NetworkType: networkType,
+		}
+		var res = protocol.IPv6EnableResponse{}
+		if err := p2pubapi.Call(*api, args, &res); err != nil {
+			return err
+		}
+	} else {
+		args := protocol.IPv6Disable{
+			GisServiceCode: gis,
+			IvmServiceCode: ivm,
+			NetworkType: networkType,
+		}
+		var res = protocol.IPv6DisableResponse{}
+		if err := p2pubapi.Call(*api, args, &res); err != nil {
+			return err
+		}
+	}
+	if err := p2pubapi.WaitVM(api, gis, ivm, p2pubapi.InService, p2pubapi.Stopped, timeout); err != nil {
+		return err
+	}
+	return nil
+}
+
+// setVMConnInfo prefers global IPv4, global IPv6 and then PrivateStandard IPv4.
+// WinRM is used for Windows
+func setVMConnInfo(d *schema.ResourceData, info *protocol.VMGetResponse) {
+	host := ""
+	for _, t := range []string{"Global", "PrivateStandard"} {
+		for _, net := range info.NetworkList {
+			if net.NetworkType != t || len(net.IpAddressList) == 0 {
+				continue
+			}
+			host = net.IpAddressList[0].IPv4.IpAddress
+			if host == "" && t == "Global" {
+				host = net.IpAddressList[0].IPv6.IpAddress
+			}
+			if host != "" {
+				break
+			}
+		}
+		if host != "" {
+			break
+		}
+	}
+	if host == "" {
+		return
+	}
+	if d.Get("os_type").(string) == "Windows" {
+		d.SetConnInfo(map[string]string{
+			"type": "winrm",
+			"user": "Administrator",
+			"host": host,
+		})
+		return
+	}
+	d.SetConnInfo(map[string]string {
+		"type": "ssh",
+		"user": "root",
+		"host": host,
+	})
+}
+
+func privateNetworkMacAddresses(info *protocol.VMGetResponse) map[string]string {
+	macs := make(map[string]string)
+	for _, net := range info.NetworkList {
+		if net.NetworkType == "Private" {
+			macs[net.MacAddress] = net.ServiceCode
+		}
+	}
+	return macs
+}
+
+// privateNetworkInterfaces returns the NICs on Private Network/V in the
+// order of the VM, as network_interface blocks
+func privateNetworkInterfaces(info *protocol.VMGetResponse) []map[string]interface{} {
+	nics := make([]map[string]interface{}, 0)
+	for _, net := range info.NetworkList {
+		if net.NetworkType == "Private" {
+			nics = append(nics, map[string]interface{}{
+				"private_network_id": net.ServiceCode,
+				"mac_address": net.MacAddress,
+				"label": net.Label,
+			})
+		}
+	}
+	return nics
+}
+
+// refreshNetworkInterfaces updates blocks in order from the NICs attached to
+// the VM. a block without mac_address, left by an interrupted apply, takes
+// the first NIC on its network not taken by another block. a block whose NIC
+// is gone is dropped, so that the NIC is attached again
+func refreshNetworkInterfaces(blocks []interface{}, nics []map[string]interface{}) []map[string]interface{} {
+	taken := make(map[string]bool)
+	for _, b := range blocks {
+		taken[b.(map[string]interface{})["mac_address"].(string)] = true
+	}
+
+	result := make([]map[string]interface{}, 0)
+	for _, b := range blocks {
+		block := b.(map[string]interface{})
+		mac := block["mac_address"].(string)
+		for _, nic := range nics {
+			if mac == "" && nic["private_network_id"] == block["private_network_id"] && !taken[nic["mac_address"].(string)] {
+				taken[nic["mac_address"].(string)] = true
+				result = append(result, nic)
+				break
+			}
+			if mac != "" && nic["mac_address"] == mac {
+				result = append(result, nic)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// resourceVirtualServerImport fills network_interface with all NICs on
+// Private Network/V, since Read only refreshes the blocks in the state
+func resourceVirtualServerImport(d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	res, err := getVMInfo(m.(*Context).API, m.(*Context).GisServiceCode, d.Id())
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Set("network_interface", privateNetworkInterfaces(res)); err != nil {
+		return nil, err
+	}
+	return []*schema.ResourceData{d}, nil
+}
+
+// attachNetworkInterface connects the VM to ivl and returns the MAC address
+// of the new NIC
+func attachNetworkInterface(api *p2pubapi.API, gis, ivm, ivl string, timeout time.Duration) (string, error) {
+	before, err := getVMInfo(api, gis, ivm)
+	if err != nil {
+		return "", err
+	}
+	if err := attachPrivateNetowrk(api, gis, ivm, ivl, timeout); err != nil {
+		return "", err
+	}
+	after, err := getVMInfo(api, gis, ivm)
+	if err != nil {
+		return "", err
+	}
+	known := privateNetworkMacAddresses(before)
+	for mac, code := range privateNetworkMacAddresses(after) {
+		if _, ok := known[mac]; !ok && code == ivl {
+			return mac, nil
+		}
+	}
+	return "", fmt.Errorf("cannot find the NIC attached to %s", ivl)
+}
+
+func setNetworkInterfaceLabel(api *p2pubapi.API, gis, ivm, mac, label string) error {
+	args := protocol.VMNetworkLabelSet{
+		GisServiceCode: gis,
+		IvmServiceCode: ivm,
+		MacAddress: mac,
+		Name: label,
+	}
+	var res = protocol.VMNetworkLabelSetResponse{}
+	if err := p2pubapi.Call(*api, args, &res); err != nil {
+		return err
+	}
+	return nil
+}
+
+// sameNetworkInterface reports whether the NIC in old can be kept for new
+func sameNetworkInterface(old, new map[string]interface{}) bool {
+	if old["private_network_id"] != new["private_network_id"] {
+		return false
+	}
+	return new["mac_address"] == "" || new["mac_address"] == old["mac_address"]
+}
+
+// applyNetworkInterfaces attaches NICs in blocks from index start, adopting
+// the ones pinned by mac_address, and returns blocks with MAC addresses filled
+func applyNetworkInterfaces(api *p2pubapi.API, gis, ivm string, blocks []interface{}, start int, timeout time.Duration) ([]map[string]interface{}, error) {
+	info, err := getVMInfo(api, gis, ivm)
+	if err != nil {
+		return nil, err
+	}
+	attached := privateNetworkMacAddresses(info)
+
+	result := make([]map[string]interface{}, 0)
+	for i, b := range blocks {
+		block := b.(map[string]interface{})
+		nic := map[string]interface{}{
+			"private_network_id": block["private_network_id"],
+			"mac_address": block["mac_address"],
+			"label": block["label"],
+		}
+		if i >= start {
+			ivl := block["private_network_id"].(string)
+			mac := block["mac_address"].(string)
+			if mac == "" {
+				if mac, err = attachNetworkInterface(api, gis, ivm, ivl, timeout); err != nil {
+					return result, err
+				}
+			} else if attached[mac] != ivl {
+				return result, fmt.Errorf("NIC %s is not attached to %s", mac, ivl)
+			}
+			nic["mac_address"] = mac
+			if block["label"].(string) != "" {
+				if err := setNetworkInterfaceLabel(api, gis, ivm, mac, block["label"].(string)); err != nil {
+					return result, err
+				}
+			}
+		}
+		result = append(result, nic)
+	}
+	return result, nil
+}
+
 func setLabel(api *p2pubapi.API, gis, ivm, label string) error {
 	args := protocol.VMLabelSet{
 		GisServiceCode: gis,
@@ -419,6 +1027,10 @@ func resourceVirtualServerCreate(d *schema.ResourceData, m interface{}) error {
 
 	log.Printf("[DEBUG] p2pub: create virtual server resource on %s", gis)
 
+	if d.Get("enable_ipv6").(bool) && !d.Get("enable_global_ip").(bool) {
+		return errors.New("enable_ipv6 requires enable_global_ip")
+	}
+
 	args := protocol.VMAdd{
 		GisServiceCode: gis,
 		Type: d.Get("type").(string),
@@ -433,6 +1045,11 @@ func resourceVirtualServerCreate(d *schema.ResourceData, m interface{}) error {
 
 	ivm := res.ServiceCode
 
+	// attaching storages and networks may fail after VMAdd, which leaves a
+	// VM to destroy
+	d.SetId(ivm)
+	d.Partial(true)
+
 	if err := p2pubapi.WaitVM(api, gis, ivm, p2pubapi.InService, p2pubapi.Stopped, timeout); err != nil {
 		return err;
 	}
@@ -458,43 +1075,55 @@ func resourceVirtualServerCreate(d *schema.ResourceData, m interface{}) error {
 	}
 
 	if d.Get("private_network") != nil {
+		macs := make(map[string]interface{})
 		for _, ivl := range d.Get("private_network").(*schema.Set).List() {
-			if err := attachPrivateNetowrk(api, gis, ivm, ivl.(string), timeout); err != nil {
+			mac, err := attachNetworkInterface(api, gis, ivm, ivl.(string), timeout)
+			if err != nil {
 				return err
 			}
+			macs[ivl.(string)] = mac
 		}
+		d.Set("private_network_mac_addresses", macs)
 	}
 
-	if d.Get("enable_global_ip") != nil && d.Get("enable_global_ip").(bool) {
-		global_ip, err := allocateGlobalIP(api, gis, ivm, timeout);
+	if blocks := d.Get("network_interface").([]interface{}); len(blocks) != 0 {
+		nics, err := applyNetworkInterfaces(api, gis, ivm, blocks, 0, timeout)
 		if err != nil {
 			return err
 		}
-		d.SetConnInfo(map[string]string {
-			"type": "ssh",
-			"user": "root",
-			"host": global_ip,
-		})
-	} else {
-		for _, net := range res.NetworkList {
-			if net.NetworkType == "PrivateStandard" {
-				d.SetConnInfo(map[string]string {
-					"type": "ssh",
-					"user": "root",
-					"host": net.IpAddressList[0].IPv4.IpAddress,
-				})
-				break
+		d.Set("network_interface", nics)
+	}
+
+	if d.Get("enable_global_ip") != nil && d.Get("enable_global_ip").(bool) {
+		if _, err := allocateGlobalIP(api, gis, ivm, timeout); err != nil {
+			return err
+		}
+		if d.Get("enable_ipv6").(bool) {
+			if err := setIPv6(api, gis, ivm, "Global", true, timeout); err != nil {
+				return err
 			}
 		}
 	}
 
-	if bootable(d) {
+	if d.Get("enable_private_standard_ipv6").(bool) {
+		if err := setIPv6(api, gis, ivm, "PrivateStandard", true, timeout); err != nil {
+			return err
+		}
+	}
+
+	if info, err := getVMInfo(api, gis, ivm); err != nil {
+		return err
+	} else {
+		setVMConnInfo(d, info)
+	}
+
+	if powerOnOff(d) == "On" {
 		if err := power(api, gis, ivm, "On", timeout); err != nil {
 			return err;
 		}
 	}
 
-	d.SetId(ivm)
+	d.Partial(false)
 
 	return resourceVirtualServerRead(d, m)
 }
@@ -509,12 +1138,24 @@ func resourceVirtualServerRead(d *schema.ResourceData, m interface{}) error {
 		return err
 	}
 
+	// the data source shares this function, but has no force_stop
+	if _, ok := d.Get("force_stop").(bool); ok {
+		m.(*Context).setVMShutdownTimeout(d.Id(), vmShutdownTimeout(d, m.(*Context)))
+	}
+
 	d.Set("server_group", res.ServerGroup)
 	d.Set("label", res.Label)
 	d.Set("category", res.Category)
 	d.Set("serverspec_cpu", res.ServerSpec.CPU)
 	d.Set("serverspec_memory", res.ServerSpec.Memory)
 
+	switch res.ResourceStatus {
+	case p2pubapi.Running.String():
+		d.Set("power_state", "running")
+	case p2pubapi.Stopped.String():
+		d.Set("power_state", "stopped")
+	}
+
 	// set network_list
 	network_list := make([]map[string]interface{}, 0)
 	for _, elm := range res.NetworkList {
@@ -540,6 +1181,44 @@ func resourceVirtualServerRead(d *schema.ResourceData, m interface{}) error {
 		return err
 	}
 
+	// keep track of the NICs attached for private_network. the first NIC on
+	// the network is adopted for states without private_network_mac_addresses
+	if d.Get("private_network") != nil && d.Get("private_network").(*schema.Set).Len() != 0 {
+		owned := d.Get("private_network_mac_addresses").(map[string]interface{})
+		attached := privateNetworkMacAddresses(res)
+		macs := make(map[string]interface{})
+		for _, v := range d.Get("private_network").(*schema.Set).List() {
+			ivl := v.(string)
+			if mac, ok := owned[ivl]; ok && attached[mac.(string)] == ivl {
+				macs[ivl] = mac
+				continue
+			}
+			for _, elm := range res.NetworkList {
+				if elm.NetworkType == "Private" && elm.ServiceCode == ivl {
+					macs[ivl] = elm.MacAddress
+					break
+				}
+			}
+		}
+		d.Set("private_network_mac_addresses", macs)
+	}
+
+	// map NICs back to network_interface blocks by MAC address
+	if blocks := d.Get("network_interface").([]interface{}); len(blocks) != 0 {
+		if err := d.Set("network_interface", refreshNetworkInterfaces(blocks, privateNetworkInterfaces(res))); err != nil {
+			return err
+		}
+	}
+
+	for _, elm := range res.NetworkList {
+		switch elm.NetworkType {
+		case "Global":
+			d.Set("enable_ipv6", elm.IPv6Enabled == "Yes")
+		case "PrivateStandard":
+			d.Set("enable_private_standard_ipv6", elm.IPv6Enabled == "Yes")
+		}
+	}
+
 	// set storage_list
 	storage_list := make([]map[string]interface{}, 0)
 	for _, elm := range res.StorageList {
@@ -560,13 +1239,22 @@ func resourceVirtualServerRead(d *schema.ResourceData, m interface{}) error {
 
 func resourceVirtualServerUpdate(d *schema.ResourceData, m interface {}) error {
 
-	api := m.(*Context).API
-	gis := m.(*Context).GisServiceCode
+	ctx := m.(*Context)
+	api := ctx.API
+	gis := ctx.GisServiceCode
 	timeout := d.Timeout(schema.TimeoutUpdate)
 
+	ctx.setVMShutdownTimeout(d.Id(), vmShutdownTimeout(d, ctx))
+
 	d.Partial(true)
 
 	stopped := false
+	defer func() {
+		// release the hold when returning in the middle of update
+		if stopped {
+			ctx.releaseVM(d.Id(), "", timeout)
+		}
+	}()
 
 	if d.HasChange("label") {
 		if err := setLabel(api, gis, d.Id(), d.Get("label").(string)); err != nil {
@@ -577,8 +1265,12 @@ func resourceVirtualServerUpdate(d *schema.ResourceData, m interface {}) error {
 
 	if d.HasChange("type") {
 		log.Printf("[DEBUG] p2pub: %s - change VM type to %s", d.Id(), d.Get("type"))
+		// refuse before stopping the VM for a type P2PUB does not have
+		if findServerType(d.Get("type").(string)) == nil {
+			return fmt.Errorf("unknown server type %s. see data source p2pub_server_types", d.Get("type").(string))
+		}
 		if !stopped {
-			if err := power(api, gis, d.Id(), "Off", timeout); err != nil {
+			if err := ctx.holdVMStopped(d.Id(), vmShutdownTimeout(d, ctx), timeout); err != nil {
 				return err;
 			}
 			stopped = true
@@ -601,7 +1293,7 @@ func resourceVirtualServerUpdate(d *schema.ResourceData, m interface {}) error {
 	if d.HasChange("system_storage") {
 		log.Printf("[DEBUG] p2pub: %s - change boot device %s", d.Id(), d.Get("system_storage"))
 		if !stopped {
-			if err := power(api, gis, d.Id(), "Off", timeout); err != nil {
+			if err := ctx.holdVMStopped(d.Id(), vmShutdownTimeout(d, ctx), timeout); err != nil {
 				return err
 			}
 			stopped = true
@@ -620,18 +1312,19 @@ func resourceVirtualServerUpdate(d *schema.ResourceData, m interface {}) error {
 	if d.HasChange("data_storage") {
 		log.Printf("[DEBUG] p2pub: %s - change data device %s", d.Id(), d.Get("data_storage"))
 		if !stopped {
-			if err := power(api, gis, d.Id(), "Off", timeout); err != nil {
+			if err := ctx.holdVMStopped(d.Id(), vmShutdownTimeout(d, ctx), timeout); err != nil {
 				return err
 			}
 			stopped = true
 		}
-		if err := power(api, gis, d.Id(), "Off", timeout); err != nil {
-			return err
-		}
+		// storages attached by p2pub_storage_attachment are left as is
+		o, n := d.GetChange("data_storage")
+		removed := o.(*schema.Set).Difference(n.(*schema.Set))
 		for _, elm := range d.Get("storage_list").([]interface{}) {
 			boot := elm.(map[string]interface{})["boot"].(string)
 			pci_slot := elm.(map[string]interface{})["pci_slot"].(string)
-			if boot == "Yes" {
+			service_code := elm.(map[string]interface{})["service_code"].(string)
+			if boot == "Yes" || !removed.Contains(service_code) {
 				continue;
 			}
 			if err := detachDataDevice(api, gis, d.Id(), pci_slot, timeout); err != nil {
@@ -639,7 +1332,7 @@ func resourceVirtualServerUpdate(d *schema.ResourceData, m interface {}) error {
 			}
 			log.Printf("[DEBUG] detach data device %v", pci_slot)
 		}
-		for _, ibg := range d.Get("data_storage").(*schema.Set).List() {
+		for _, ibg := range n.(*schema.Set).Difference(o.(*schema.Set)).List() {
 			if err := attachDataDevice(api, gis, d.Id(), ibg.(string), timeout); err != nil {
 				return err
 			}
@@ -650,32 +1343,102 @@ func resourceVirtualServerUpdate(d *schema.ResourceData, m interface {}) error {
 	if d.HasChange("private_network") {
 		log.Printf("[DEBUG] p2pub: %s - change private network %s", d.Id(), d.Get("private_network"))
 		if !stopped {
-			if err := power(api, gis, d.Id(), "Off", timeout); err != nil {
+			if err := ctx.holdVMStopped(d.Id(), vmShutdownTimeout(d, ctx), timeout); err != nil {
 				return err
 			}
 			stopped = true
 		}
-		for _, elm := range d.Get("network_list").([]interface{}) {
-			t := elm.(map[string]interface{})["network_type"].(string)
-			mac_address := elm.(map[string]interface{})["mac_address"].(string)
-			if t == "Private" {
-				if err := detachPrivateNetwork(api, gis, d.Id(), mac_address, timeout); err != nil {
+		// only the NICs this resource attached are detached, so that NICs of
+		// p2pub_private_network_attachment on the same networks are left as is
+		o, n := d.GetChange("private_network")
+		macs := make(map[string]interface{})
+		for ivl, mac := range d.Get("private_network_mac_addresses").(map[string]interface{}) {
+			macs[ivl] = mac
+		}
+		for _, ivl := range o.(*schema.Set).Difference(n.(*schema.Set)).List() {
+			mac, ok := macs[ivl.(string)]
+			if !ok {
+				log.Printf("[WARN] p2pub: %s - NIC on %s is unknown. not detached", d.Id(), ivl)
+				continue
+			}
+			if err := detachPrivateNetwork(api, gis, d.Id(), mac.(string), timeout); err != nil {
+				return err
+			}
+			delete(macs, ivl.(string))
+		}
+		for _, ivl := range n.(*schema.Set).Difference(o.(*schema.Set)).List() {
+			mac, err := attachNetworkInterface(api, gis, d.Id(), ivl.(string), timeout)
+			if err != nil {
+				return err
+			}
+			macs[ivl.(string)] = mac
+		}
+		d.Set("private_network_mac_addresses", macs)
+		d.SetPartial("private_network")
+		d.SetPartial("private_network_mac_addresses")
+	}
+
+	if d.HasChange("network_interface") {
+		log.Printf("[DEBUG] p2pub: %s - change network interfaces %v", d.Id(), d.Get("network_interface"))
+		o, n := d.GetChange("network_interface")
+		olds := o.([]interface{})
+		news := n.([]interface{})
+
+		// NICs before the first difference are kept as is, so that the
+		// order of NICs follows the order of blocks
+		keep := 0
+		for keep < len(olds) && keep < len(news) &&
+			sameNetworkInterface(olds[keep].(map[string]interface{}), news[keep].(map[string]interface{})) {
+			keep++
+		}
+
+		for i := 0; i < keep; i++ {
+			old := olds[i].(map[string]interface{})
+			new := news[i].(map[string]interface{})
+			if old["label"] != new["label"] {
+				if err := setNetworkInterfaceLabel(api, gis, d.Id(), old["mac_address"].(string), new["label"].(string)); err != nil {
 					return err
 				}
 			}
+			new["mac_address"] = old["mac_address"]
 		}
-		for _, ivl := range d.Get("private_network").(*schema.Set).List() {
-			if err := attachPrivateNetowrk(api, gis, d.Id(), ivl.(string), timeout); err != nil {
+
+		if keep < len(olds) || keep < len(news) {
+			if !stopped {
+				if err := ctx.holdVMStopped(d.Id(), vmShutdownTimeout(d, ctx), timeout); err != nil {
+					return err
+				}
+				stopped = true
+			}
+		}
+
+		// NICs pinned by mac_address in the new blocks are adopted, not detached
+		pinned := make(map[string]bool)
+		for _, b := range news[keep:] {
+			pinned[b.(map[string]interface{})["mac_address"].(string)] = true
+		}
+		for _, b := range olds[keep:] {
+			mac := b.(map[string]interface{})["mac_address"].(string)
+			if mac == "" || pinned[mac] {
+				continue
+			}
+			if err := detachPrivateNetwork(api, gis, d.Id(), mac, timeout); err != nil {
 				return err
 			}
 		}
-		d.SetPartial("private_network")
+
+		nics, err := applyNetworkInterfaces(api, gis, d.Id(), news, keep, timeout)
+		d.Set("network_interface", nics)
+		if err != nil {
+			return err
+		}
+		d.SetPartial("network_interface")
 	}
 
 	if d.HasChange("enable_global_ip") {
 		log.Printf("[DEBUG] p2pub: %s - change global ip address assignment %s", d.Id(), d.Get("enable_global_ip"))
 		if !stopped {
-			if err := power(api, gis, d.Id(), "Off", timeout); err != nil {
+			if err := ctx.holdVMStopped(d.Id(), vmShutdownTimeout(d, ctx), timeout); err != nil {
 				return err
 			}
 			stopped = true
@@ -692,11 +1455,60 @@ func resourceVirtualServerUpdate(d *schema.ResourceData, m interface {}) error {
 		d.SetPartial("enable_global_ip")
 	}
 
+	if d.HasChange("enable_ipv6") || d.HasChange("enable_global_ip") && d.Get("enable_ipv6").(bool) {
+		log.Printf("[DEBUG] p2pub: %s - change global IPv6 %v", d.Id(), d.Get("enable_ipv6"))
+		if d.Get("enable_ipv6").(bool) && !d.Get("enable_global_ip").(bool) {
+			return errors.New("enable_ipv6 requires enable_global_ip")
+		}
+		if d.Get("enable_global_ip").(bool) {
+			if !stopped {
+				if err := ctx.holdVMStopped(d.Id(), vmShutdownTimeout(d, ctx), timeout); err != nil {
+					return err
+				}
+				stopped = true
+			}
+			if err := setIPv6(api, gis, d.Id(), "Global", d.Get("enable_ipv6").(bool), timeout); err != nil {
+				return err
+			}
+		}
+		d.SetPartial("enable_ipv6")
+	}
+
+	if d.HasChange("enable_private_standard_ipv6") {
+		log.Printf("[DEBUG] p2pub: %s - change PrivateStandard IPv6 %v", d.Id(), d.Get("enable_private_standard_ipv6"))
+		if !stopped {
+			if err := ctx.holdVMStopped(d.Id(), vmShutdownTimeout(d, ctx), timeout); err != nil {
+				return err
+			}
+			stopped = true
+		}
+		if err := setIPv6(api, gis, d.Id(), "PrivateStandard", d.Get("enable_private_standard_ipv6").(bool), timeout); err != nil {
+			return err
+		}
+		d.SetPartial("enable_private_standard_ipv6")
+	}
+
 	d.Partial(false)
 
-	if stopped && bootable(d) {
-		if err := power(api, gis, d.Id(), "On", timeout); err != nil {
-			return err;
+	if stopped {
+		stopped = false
+		if err := ctx.releaseVM(d.Id(), powerOnOff(d), timeout); err != nil {
+			return err
+		}
+	} else if d.HasChange("power_state") {
+		log.Printf("[DEBUG] p2pub: %s - change power state to %s", d.Id(), d.Get("power_state"))
+		switch d.Get("power_state") {
+		case "running":
+			if !bootable(d) {
+				return errors.New("power_state running requires system_storage")
+			}
+			if err := ctx.setVMPower(d.Id(), "On", vmShutdownTimeout(d, ctx), timeout); err != nil {
+				return err
+			}
+		case "stopped":
+			if err := ctx.setVMPower(d.Id(), "Off", vmShutdownTimeout(d, ctx), timeout); err != nil {
+				return err
+			}
 		}
 	}
 	
@@ -2,6 +2,7 @@ package p2pub
 
 import (
 	"testing"
+	"time"
 
 	"github.com/hashicorp/terraform/helper/resource"
 )
@@ -33,3 +34,75 @@ func TestVirtualServer(t *testing.T) {
 		},
 	})
 }
+
+func TestRefreshNetworkInterfaces(t *testing.T) {
+	nics := []map[string]interface{}{
+		{"private_network_id": "ivl1", "mac_address": "m1", "label": "a"},
+		{"private_network_id": "ivl2", "mac_address": "m2", "label": ""},
+		{"private_network_id": "ivl1", "mac_address": "m3", "label": "c"},
+	}
+	blocks := []interface{}{
+		map[string]interface{}{"private_network_id": "ivl1", "mac_address": "m3", "label": "c"},
+		map[string]interface{}{"private_network_id": "ivl1", "mac_address": "", "label": ""},
+		map[string]interface{}{"private_network_id": "ivl2", "mac_address": "m9", "label": ""},
+		map[string]interface{}{"private_network_id": "ivl2", "mac_address": "m2", "label": ""},
+	}
+	result := refreshNetworkInterfaces(blocks, nics)
+	macs := []string{}
+	for _, nic := range result {
+		macs = append(macs, nic["mac_address"].(string))
+	}
+	// m3 keeps its place, the empty block takes m1, and the gone m9 is dropped
+	if len(macs) != 3 || macs[0] != "m3" || macs[1] != "m1" || macs[2] != "m2" {
+		t.Fatalf("unexpected network interfaces: %v", macs)
+	}
+}
+
+func TestVMPowerHold(t *testing.T) {
+	// only the last release starts a VM which was running before the holds
+	hold := &vmPowerHold{}
+	hold.acquire(true)
+	hold.acquire(false)
+	if hold.release("") {
+		t.Fatalf("the VM should not be started while held")
+	}
+	if !hold.release("") {
+		t.Fatalf("the VM should be started by the last release")
+	}
+	if hold.release("") {
+		t.Fatalf("a release without a hold should not start the VM")
+	}
+
+	// "Off" wins over "On" in either order
+	hold = &vmPowerHold{}
+	hold.acquire(true)
+	hold.acquire(true)
+	hold.release("Off")
+	if hold.release("On") {
+		t.Fatalf("Off should win over a later On")
+	}
+	hold.acquire(false)
+	hold.acquire(false)
+	hold.release("On")
+	if hold.release("Off") {
+		t.Fatalf("Off should win over an earlier On")
+	}
+
+	// "On" starts a VM which was stopped before the holds
+	hold.acquire(false)
+	hold.requestPower("On")
+	if !hold.release("") {
+		t.Fatalf("On should start the VM")
+	}
+}
+
+func TestVMShutdownTimeoutOf(t *testing.T) {
+	c := &Context{ShutdownTimeout: 5 * time.Minute}
+	if c.vmShutdownTimeoutOf("ivm1") != 5*time.Minute {
+		t.Fatalf("the provider's shutdown timeout should be used for an unknown VM")
+	}
+	c.setVMShutdownTimeout("ivm1", 0)
+	if c.vmShutdownTimeoutOf("ivm1") != 0 {
+		t.Fatalf("the VM's shutdown timeout should be used")
+	}
+}
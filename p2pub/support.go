@@ -1,7 +1,58 @@
 package p2pub
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
 const (
 	PRIVATE_NETWORK_MAX_ATTACH_COUNT = 5
 	DATA_STORAGE_MAX_ATTACH_COUNT = 8
 )
 
+// splitId splits the id of resources which are identified by two service
+// codes (or names) joined with "/"
+func splitId(id string) (string, string, error) {
+	parts := strings.SplitN(id, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid id: %s", id)
+	}
+	return parts[0], parts[1], nil
+}
+
+func validateDuration(v interface{}, k string) ([]string, []error) {
+	if _, err := time.ParseDuration(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%s is not a valid duration: %s", k, err)}
+	}
+	return nil, nil
+}
+
+const passwordHashPrefix = "sha256:"
+
+// hashPassword returns the hash of password stored in the state instead of
+// the password itself
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return passwordHashPrefix + hex.EncodeToString(sum[:])
+}
+
+func suppressHashedPasswordDiff(k, old, new string, d *schema.ResourceData) bool {
+	return strings.HasPrefix(old, passwordHashPrefix) && old == hashPassword(new)
+}
+
+// validatePasswordHashOnlyDiff rejects turning hashOnlyKey off while only the
+// hash of key is in the state, since the password cannot be restored from it
+func validatePasswordHashOnlyDiff(d *schema.ResourceDiff, key, hashOnlyKey string) error {
+	if !d.HasChange(hashOnlyKey) || d.Get(hashOnlyKey).(bool) {
+		return nil
+	}
+	if strings.HasPrefix(d.Get(key).(string), passwordHashPrefix) {
+		return fmt.Errorf("only the hash of %s is stored. change %s as well when turning %s off", key, key, hashOnlyKey)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package p2pub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// size limit of UserData after base64 encoding
+const USERDATA_MAX_SIZE = 16 * 1024
+
+// content types understood by cloud-init
+var userDataContentTypes = []string{
+	"text/cloud-config",
+	"text/x-shellscript",
+	"text/cloud-boothook",
+	"text/part-handler",
+	"text/upstart-job",
+	"text/x-include-url",
+	"text/jinja2",
+}
+
+type userDataPart struct {
+	ContentType string
+	Filename    string
+	Content     string
+}
+
+// hashUserData is the StateFunc of userdata contents, so that only a hash is
+// stored in the state
+func hashUserData(v interface{}) string {
+	sum := sha256.Sum256([]byte(v.(string)))
+	return hex.EncodeToString(sum[:])
+}
+
+// suppressLegacyUserDataDiff suppresses the diff of userdata which is still
+// stored in plain text in the state
+func suppressLegacyUserDataDiff(k, old, new string, d *schema.ResourceData) bool {
+	return old != "" && hashUserData(old) == new
+}
+
+func validateCloudConfig(v interface{}, k string) ([]string, []error) {
+	if !strings.HasPrefix(v.(string), "#cloud-config") {
+		return nil, []error{fmt.Errorf("%s must start with #cloud-config", k)}
+	}
+	return nil, nil
+}
+
+// buildUserData builds the UserData string passed to the API. cloudConfig
+// is sent as is when there are no parts, otherwise it becomes the first part
+// of a multipart MIME message
+func buildUserData(cloudConfig string, parts []userDataPart, compress bool) (string, error) {
+	var payload []byte
+
+	if len(parts) == 0 {
+		payload = []byte(cloudConfig)
+	} else {
+		if cloudConfig != "" {
+			parts = append([]userDataPart{{ContentType: "text/cloud-config", Content: cloudConfig}}, parts...)
+		}
+		var err error
+		if payload, err = buildMultipartUserData(parts); err != nil {
+			return "", err
+		}
+	}
+
+	if compress {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(payload); err != nil {
+			return "", err
+		}
+		if err := w.Close(); err != nil {
+			return "", err
+		}
+		payload = buf.Bytes()
+	}
+
+	userdata := base64.StdEncoding.EncodeToString(payload)
+	if len(userdata) > USERDATA_MAX_SIZE {
+		return "", fmt.Errorf("userdata is too large: %d bytes after encoding (max %d). consider userdata_gzip", len(userdata), USERDATA_MAX_SIZE)
+	}
+
+	return userdata, nil
+}
+
+func buildMultipartUserData(parts []userDataPart) ([]byte, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	// derive the boundary from the contents to keep the output stable
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part.ContentType))
+		h.Write([]byte(part.Content))
+	}
+	if err := w.SetBoundary("MIMEBOUNDARY" + hex.EncodeToString(h.Sum(nil))[:32]); err != nil {
+		return nil, err
+	}
+
+	for i, part := range parts {
+		filename := part.Filename
+		if filename == "" {
+			filename = fmt.Sprintf("part-%03d", i+1)
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", fmt.Sprintf("%s; charset=\"utf-8\"", part.ContentType))
+		header.Set("MIME-Version", "1.0")
+		header.Set("Content-Transfer-Encoding", "7bit")
+		header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+		pw, err := w.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := pw.Write([]byte(part.Content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=\"%s\"\r\n", w.Boundary())
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n\r\n")
+	buf.Write(body.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+// systemStorageUserData returns the UserData of a system storage given by
+// userdata (already encoded), or cloud_config and userdata_part
+func systemStorageUserData(d *schema.ResourceData) (string, error) {
+	if userdata := d.Get("userdata").(string); userdata != "" {
+		return userdata, nil
+	}
+
+	parts := make([]userDataPart, 0)
+	for _, p := range d.Get("userdata_part").([]interface{}) {
+		part := p.(map[string]interface{})
+		parts = append(parts, userDataPart{
+			ContentType: part["content_type"].(string),
+			Filename:    part["filename"].(string),
+			Content:     part["content"].(string),
+		})
+	}
+
+	cloudConfig := d.Get("cloud_config").(string)
+	if cloudConfig == "" && len(parts) == 0 {
+		return "", nil
+	}
+
+	return buildUserData(cloudConfig, parts, d.Get("userdata_gzip").(bool))
+}
+
+// attributeDiff is implemented by both schema.ResourceData and
+// schema.ResourceDiff
+type attributeDiff interface {
+	HasChange(string) bool
+	GetChange(string) (interface{}, interface{})
+}
+
+// userDataChanged reports whether any of the attributes making up UserData
+// has changed
+func userDataChanged(d attributeDiff) bool {
+	return d.HasChange("userdata") || d.HasChange("cloud_config") ||
+		d.HasChange("userdata_part") || d.HasChange("userdata_gzip")
+}
+
+// staleUserDataKeys returns the UserData inputs which are set but unchanged.
+// only their hashes are known, so UserData cannot be built from them
+func staleUserDataKeys(d attributeDiff) []string {
+	keys := []string{"userdata", "cloud_config"}
+	o, _ := d.GetChange("userdata_part")
+	for i := range o.([]interface{}) {
+		keys = append(keys, fmt.Sprintf("userdata_part.%d.content", i))
+	}
+
+	stale := make([]string, 0)
+	for _, key := range keys {
+		if old, _ := d.GetChange(key); old.(string) != "" && !d.HasChange(key) {
+			stale = append(stale, key)
+		}
+	}
+	return stale
+}
+
+// validateUserDataResend returns an error when UserData has to be sent again
+// while some of its inputs are only known by their hashes
+func validateUserDataResend(d attributeDiff) error {
+	if stale := staleUserDataKeys(d); len(stale) != 0 {
+		return fmt.Errorf("UserData is sent as a whole, but only the hashes of unchanged %s are stored in the state. "+
+			"change %s in the same apply as well, e.g. by a comment with a revision number in each", strings.Join(stale, ", "), strings.Join(stale, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package p2pub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testCloudConfig = "#cloud-config\npackages:\n  - nginx\n"
+
+func TestBuildUserDataCloudConfig(t *testing.T) {
+	userdata, err := buildUserData(testCloudConfig, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _ := base64.StdEncoding.DecodeString(userdata)
+	if string(decoded) != testCloudConfig {
+		t.Fatalf("unexpected userdata: %s", decoded)
+	}
+}
+
+func TestBuildUserDataMultipart(t *testing.T) {
+	parts := []userDataPart{
+		{ContentType: "text/x-shellscript", Content: "#!/bin/sh\necho hello\n"},
+	}
+	userdata, err := buildUserData(testCloudConfig, parts, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := buildUserData(testCloudConfig, parts, true)
+	if userdata != again {
+		t.Fatalf("userdata should be stable")
+	}
+
+	decoded, _ := base64.StdEncoding.DecodeString(userdata)
+	r, err := gzip.NewReader(bytes.NewReader(decoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := ioutil.ReadAll(r)
+	for _, s := range []string{"multipart/mixed", "text/cloud-config", "text/x-shellscript", "echo hello", "part-002"} {
+		if !strings.Contains(string(payload), s) {
+			t.Fatalf("%s not found in userdata: %s", s, payload)
+		}
+	}
+}
+
+func TestBuildUserDataTooLarge(t *testing.T) {
+	large := testCloudConfig + strings.Repeat("#", USERDATA_MAX_SIZE)
+	if _, err := buildUserData(large, nil, false); err == nil {
+		t.Fatalf("large userdata should be rejected")
+	}
+}
+
+// testUserDataDiff is an attributeDiff over old and new attribute values
+type testUserDataDiff struct {
+	old, new map[string]interface{}
+}
+
+func (d testUserDataDiff) GetChange(key string) (interface{}, interface{}) {
+	zero := func(v interface{}) interface{} {
+		if v != nil {
+			return v
+		}
+		if key == "userdata_part" {
+			return []interface{}{}
+		}
+		return ""
+	}
+	return zero(d.old[key]), zero(d.new[key])
+}
+
+func (d testUserDataDiff) HasChange(key string) bool {
+	o, n := d.GetChange(key)
+	return !reflect.DeepEqual(o, n)
+}
+
+func TestStaleUserDataKeys(t *testing.T) {
+	hash := hashUserData(testCloudConfig)
+	d := testUserDataDiff{
+		old: map[string]interface{}{
+			"cloud_config":            hash,
+			"userdata_part":           []interface{}{map[string]interface{}{"content": "a"}},
+			"userdata_part.0.content": "a",
+			"userdata_gzip":           false,
+		},
+		new: map[string]interface{}{
+			"cloud_config":            hash,
+			"userdata_part":           []interface{}{map[string]interface{}{"content": "b"}},
+			"userdata_part.0.content": "b",
+			"userdata_gzip":           true,
+		},
+	}
+	if stale := staleUserDataKeys(d); !reflect.DeepEqual(stale, []string{"cloud_config"}) {
+		t.Fatalf("unexpected stale keys: %v", stale)
+	}
+	if err := validateUserDataResend(d); err == nil || !strings.Contains(err.Error(), "change cloud_config ") {
+		t.Fatalf("unchanged cloud_config should be rejected by name: %v", err)
+	}
+
+	d.new["cloud_config"] = hashUserData(testCloudConfig + "# changed\n")
+	if err := validateUserDataResend(d); err != nil {
+		t.Fatalf("userdata should be built again: %v", err)
+	}
+}